@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/jamsi-max/merch-store/config"
 	"github.com/jamsi-max/merch-store/internal/db"
@@ -12,6 +16,8 @@ import (
 const (
 	red   = "\033[31m"
 	reset = "\033[0m"
+
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
@@ -26,9 +32,30 @@ func main() {
 	}
 	defer db.DB.Close()
 
-	server := router.SetupRouter(db, cfg.JWTSecret)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: router.SetupRouter(ctx, db, cfg),
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
 
-	if err := server.Run(":8080"); err != nil && err != http.ErrServerClosed {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf(red+"[ERR]"+reset+" failed to shutdown server: %v", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf(red+"[ERR]"+reset+" failed to start server: %v", err)
 	}
+
+	<-shutdownDone
 }
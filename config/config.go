@@ -2,13 +2,24 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	DatabaseURL string `mapstructure:"DATABASE_URL"`
-	JWTSecret   string `mapstructure:"JWT_SECRET"`
+	DatabaseURL     string `mapstructure:"DATABASE_URL"`
+	JWTSecret       string `mapstructure:"JWT_SECRET"`
+	JWTKeysDir      string `mapstructure:"JWT_KEYS_DIR"`
+	JWTSigningKeyID string `mapstructure:"JWT_SIGNING_KEY_ID"`
+	StartingBalance int    `mapstructure:"STARTING_BALANCE"`
+	AuthAutoSignup  bool   `mapstructure:"AUTH_AUTO_SIGNUP"`
+	LegacyBuyRoute  bool   `mapstructure:"LEGACY_BUY_ROUTE"`
+	AllowanceAmount int    `mapstructure:"ALLOWANCE_AMOUNT"`
+
+	AccessTokenTTL  time.Duration `mapstructure:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `mapstructure:"REFRESH_TOKEN_TTL"`
+	IdempotencyTTL  time.Duration `mapstructure:"IDEMPOTENCY_TTL"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -17,6 +28,15 @@ func LoadConfig() (*Config, error) {
 
 	viper.SetDefault("DATABASE_URL", "postgres://admin:secrets@db:5432/merch_store?sslmode=disable")
 	viper.SetDefault("JWT_SECRET", "super_puper_mega_secrets_key_jwt")
+	viper.SetDefault("JWT_KEYS_DIR", "")
+	viper.SetDefault("JWT_SIGNING_KEY_ID", "")
+	viper.SetDefault("STARTING_BALANCE", 1000)
+	viper.SetDefault("AUTH_AUTO_SIGNUP", false)
+	viper.SetDefault("LEGACY_BUY_ROUTE", true)
+	viper.SetDefault("ALLOWANCE_AMOUNT", 0)
+	viper.SetDefault("ACCESS_TOKEN_TTL", "15m")
+	viper.SetDefault("REFRESH_TOKEN_TTL", "720h")
+	viper.SetDefault("IDEMPOTENCY_TTL", "24h")
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Println("[ERR] no .env file found, using default values or environment variables")
@@ -0,0 +1,149 @@
+package allowance
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/ledger"
+	"github.com/jmoiron/sqlx"
+)
+
+// leaderLockKey is the advisory lock held by the one replica that accrues
+// the allowance.
+const leaderLockKey = 7_301_001
+
+// Period is the month an allowance is credited for, as YYYY-MM in UTC.
+func Period(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// Accrue credits amount to every active user not yet credited for period and
+// returns how many users were credited. Each user is credited in a
+// transaction of their own, so the accrual never holds more than one balance
+// lock; running it again for the same period credits nobody twice.
+func Accrue(ctx context.Context, database *db.Database, amount int, period string) (int, error) {
+	var userIDs []int
+	err := database.DB.SelectContext(ctx, &userIDs, `
+		SELECT id FROM users u
+		WHERE active AND NOT EXISTS (
+			SELECT 1 FROM allowance_credits c WHERE c.user_id = u.id AND c.period = $1
+		)
+		ORDER BY id`, period)
+	if err != nil {
+		return 0, err
+	}
+
+	credited := 0
+	for _, userID := range userIDs {
+		err := database.WithTx(ctx, func(tx *sqlx.Tx) error {
+			return credit(tx, userID, amount, period)
+		})
+		// The allowance_credits key means someone credited the user since
+		// the list was read.
+		if db.IsUniqueViolation(err) {
+			continue
+		}
+		if err != nil {
+			return credited, err
+		}
+		credited++
+	}
+
+	return credited, nil
+}
+
+func credit(tx *sqlx.Tx, userID, amount int, period string) error {
+	txnID, err := ledger.Allowance(tx, userID, amount, period)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO allowance_credits (user_id, period, amount, ledger_txn_id) VALUES ($1, $2, $3, $4)`,
+		userID, period, amount, txnID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO transactions (receiver_id, amount, message, kind) VALUES ($1, $2, $3, 'allowance')`,
+		userID, amount, "Monthly allowance "+period)
+	return err
+}
+
+// Run accrues the allowance for the current month on every tick, but only on
+// the replica that holds the leader lock. The lock lives as long as its
+// connection, so if the leader goes away another replica takes over on its
+// next tick. A non-positive amount disables the job.
+func Run(ctx context.Context, database *db.Database, amount int, interval time.Duration) {
+	if amount <= 0 {
+		return
+	}
+
+	var leader *sql.Conn
+	defer func() {
+		if leader != nil {
+			leader.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if leader != nil && leader.PingContext(ctx) != nil {
+			leader.Close()
+			leader = nil
+		}
+		if leader == nil {
+			leader = acquireLeadership(ctx, database)
+		}
+
+		if leader != nil {
+			period := Period(time.Now())
+			credited, err := Accrue(ctx, database, amount, period)
+			switch {
+			case err != nil:
+				if ctx.Err() == nil {
+					log.Printf("[ERR] allowance accrual for %s failed: %v", period, err)
+				}
+			case credited > 0:
+				log.Printf("[INFO] allowance for %s credited to %d users", period, credited)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquireLeadership returns the connection holding the leader lock, or nil
+// if another replica holds it.
+func acquireLeadership(ctx context.Context, database *db.Database) *sql.Conn {
+	conn, err := database.DB.Conn(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[ERR] allowance: failed to get a connection: %v", err)
+		}
+		return nil
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", leaderLockKey).Scan(&acquired)
+	if err != nil || !acquired {
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[ERR] allowance: failed to take the leader lock: %v", err)
+		}
+		conn.Close()
+		return nil
+	}
+
+	log.Println("[INFO] allowance: this replica accrues the monthly allowance")
+	return conn
+}
@@ -0,0 +1,103 @@
+package allowance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriod(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	assert.Equal(t, "2025-02", Period(time.Date(2025, 3, 1, 1, 0, 0, 0, moscow)))
+	assert.Equal(t, "2025-03", Period(time.Date(2025, 3, 31, 23, 0, 0, 0, time.UTC)))
+}
+
+func TestAccrue(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectQuery(`SELECT id FROM users u WHERE active AND NOT EXISTS`).
+		WithArgs("2025-03").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(5))
+	for i, userID := range []int{2, 5} {
+		txnID := 10 + i
+		account := []string{"user:2", "user:5"}[i]
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO ledger_txns`).
+			WithArgs("allowance", "allowance:2025-03").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(txnID))
+		mock.ExpectExec(`INSERT INTO ledger_entries`).
+			WithArgs(txnID, "system:issuance", -200).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+			WithArgs(account, userID).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO ledger_entries`).
+			WithArgs(txnID, account, 200).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+			WithArgs(200, userID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO allowance_credits \(user_id, period, amount, ledger_txn_id\)`).
+			WithArgs(userID, "2025-03", 200, txnID).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO transactions \(receiver_id, amount, message, kind\) VALUES \(\$1, \$2, \$3, 'allowance'\)`).
+			WithArgs(userID, 200, "Monthly allowance 2025-03").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+
+	database := &db.Database{DB: sqlx.NewDb(mockDB, "postgres")}
+	credited, err := Accrue(context.Background(), database, 200, "2025-03")
+	require.NoError(t, err)
+	assert.Equal(t, 2, credited)
+
+	mock.ExpectQuery(`SELECT id FROM users u WHERE active AND NOT EXISTS`).
+		WithArgs("2025-03").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	credited, err = Accrue(context.Background(), database, 200, "2025-03")
+	require.NoError(t, err)
+	assert.Zero(t, credited)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAccrue_CreditedMeanwhile(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectQuery(`SELECT id FROM users u WHERE active AND NOT EXISTS`).
+		WithArgs("2025-03").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO ledger_txns`).
+		WithArgs("allowance", "allowance:2025-03").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO ledger_accounts`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO allowance_credits`).
+		WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+
+	database := &db.Database{DB: sqlx.NewDb(mockDB, "postgres")}
+	credited, err := Accrue(context.Background(), database, 200, "2025-03")
+	require.NoError(t, err)
+	assert.Zero(t, credited)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
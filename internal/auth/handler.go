@@ -1,71 +1,308 @@
 package auth
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/config"
 	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/ledger"
+	"github.com/jmoiron/sqlx"
 )
 
+var errUserExists = errors.New("user already exists")
+
 type AuthHandler struct {
-	db        *db.Database
-	jwtSecret string
+	db              *db.Database
+	keys            *KeyRing
+	startingBalance int
+	autoSignup      bool
+	accessTTL       time.Duration
+	refreshTTL      time.Duration
+	revocations     *RevocationList
 }
 
-func NewAuthHandler(db *db.Database, jwtSecret string) *AuthHandler {
-	return &AuthHandler{db: db, jwtSecret: jwtSecret}
+func NewAuthHandler(db *db.Database, cfg *config.Config, keys *KeyRing, revocations *RevocationList) *AuthHandler {
+	return &AuthHandler{
+		db:              db,
+		keys:            keys,
+		startingBalance: cfg.StartingBalance,
+		autoSignup:      cfg.AuthAutoSignup,
+		accessTTL:       cfg.AccessTokenTTL,
+		refreshTTL:      cfg.RefreshTokenTTL,
+		revocations:     revocations,
+	}
 }
 
-func (h *AuthHandler) Auth(c *gin.Context) {
-	var req struct {
-		Username string `json:"username" binding:"required"`
-		Password string `json:"password" binding:"required"`
-	}
+type credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req credentials
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
 		return
 	}
 
-	var user User
+	if err := validateUsername(req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid username: " + err.Error()})
+		return
+	}
 
-	err := h.db.DB.Get(&user, "SELECT * FROM users WHERE name=$1", req.Username)
+	if err := validatePassword(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid password: " + err.Error()})
+		return
+	}
 
+	user, err := h.createUser(c.Request.Context(), req.Username, req.Password)
+	if errors.Is(err, errUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"errors": "User already exists"})
+		return
+	}
 	if err != nil {
-		hashedPassword, err := HashPassword(req.Password)
-		if err != nil {
-			log.Printf("[ERR] failed to hash password: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to hash password"})
-			return
-		}
+		log.Printf("[ERR] failed to create user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to create user"})
+		return
+	}
 
-		err = h.db.DB.QueryRow(`
-			INSERT INTO users (name, pass, coins) VALUES ($1, $2, 1000) RETURNING id`,
-			req.Username, hashedPassword).Scan(&user.ID)
+	tokens, err := h.issueTokens(h.db.DB, user)
+	if err != nil {
+		log.Printf("[ERR] failed to generate token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, tokens)
+}
 
+func (h *AuthHandler) Auth(c *gin.Context) {
+	var req credentials
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	var user User
+
+	err := h.db.DB.Get(&user, "SELECT id, name, pass, coins, role FROM users WHERE name=$1", req.Username)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && h.autoSignup:
+		user, err = h.createUser(c.Request.Context(), req.Username, req.Password)
 		if err != nil {
 			log.Printf("[ERR] failed to create user: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to create user"})
 			return
 		}
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Invalid username or password"})
+		return
+	case err != nil:
+		log.Printf("[ERR] failed to get user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get user"})
+		return
+	case !CheckPassword(user.Pass, req.Password):
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Invalid username or password"})
+		return
+	}
+
+	tokens, err := h.issueTokens(h.db.DB, user)
+	if err != nil {
+		log.Printf("[ERR] failed to generate token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	tx, err := h.db.DB.Beginx()
+	if err != nil {
+		log.Printf("[ERR] transaction refresh failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Transaction refresh failed"})
+		return
+	}
 
-		user.Name = req.Username
-		user.Coins = 1000
-	} else {
+	var stored refreshToken
+	err = tx.Get(&stored, `
+		SELECT rt.id, rt.user_id, u.name, u.role, rt.expires_at, rt.revoked_at
+		FROM refresh_tokens rt
+		JOIN users u ON u.id = rt.user_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt`, hashRefreshToken(req.RefreshToken))
 
-		if !CheckPassword(user.Pass, req.Password) {
-			c.JSON(http.StatusUnauthorized, gin.H{"errors": "Invalid username or password"})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		db.Rollback(tx)
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Invalid refresh token"})
+		return
+	case err != nil:
+		db.Rollback(tx)
+		log.Printf("[ERR] failed to get refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get refresh token"})
+		return
+	case stored.RevokedAt != nil:
+		// A rotated token is being replayed: assume it leaked and end every session of the user.
+		if err := revokeUserRefreshTokens(tx, stored.UserID); err != nil {
+			db.Rollback(tx)
+			log.Printf("[ERR] failed to revoke refresh tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to revoke refresh tokens"})
 			return
 		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("[ERR] failed to commit transaction: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Invalid refresh token"})
+		return
+	case stored.ExpiresAt.Before(time.Now()):
+		db.Rollback(tx)
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Refresh token expired"})
+		return
 	}
 
-	token, err := GenerateToken(user.ID, user.Name, h.jwtSecret)
+	if err := revokeRefreshToken(tx, stored.ID); err != nil {
+		db.Rollback(tx)
+		log.Printf("[ERR] failed to revoke refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to revoke refresh token"})
+		return
+	}
+
+	tokens, err := h.issueTokens(tx, User{ID: stored.UserID, Name: stored.Username, Role: stored.Role})
 	if err != nil {
+		db.Rollback(tx)
 		log.Printf("[ERR] failed to generate token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	if err := tx.Commit(); err != nil {
+		log.Printf("[ERR] failed to commit transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+		All          bool   `json:"all"`
+	}
+
+	// The body is optional; io.EOF means there was none, chunked or not.
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	if _, ok := c.Get("userID"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Unauthorized"})
+		return
+	}
+	userID := c.GetInt("userID")
+
+	if jti := c.GetString("jti"); jti != "" {
+		expiresAt := c.GetTime("tokenExpiresAt")
+		if expiresAt.IsZero() {
+			expiresAt = time.Now().Add(h.accessTTL)
+		}
+
+		if err := h.revocations.Revoke(c.Request.Context(), jti, expiresAt); err != nil {
+			log.Printf("[ERR] failed to revoke access token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to revoke token"})
+			return
+		}
+	}
+
+	var err error
+	switch {
+	case req.All:
+		err = revokeUserRefreshTokens(h.db.DB, userID)
+	case req.RefreshToken != "":
+		_, err = h.db.DB.Exec(`
+			UPDATE refresh_tokens SET revoked_at = now()
+			WHERE token_hash = $1 AND user_id = $2 AND revoked_at IS NULL`,
+			hashRefreshToken(req.RefreshToken), userID)
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to revoke refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to revoke refresh token"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *AuthHandler) issueTokens(exec sqlx.Execer, user User) (gin.H, error) {
+	token, err := GenerateToken(h.keys, user.ID, user.Name, user.Role, h.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := storeRefreshToken(exec, user.ID, h.refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":        token,
+		"refreshToken": refresh,
+		"expiresIn":    int(h.accessTTL.Seconds()),
+	}, nil
+}
+
+func (h *AuthHandler) createUser(ctx context.Context, username, password string) (User, error) {
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{Name: username, Pass: hashedPassword, Coins: h.startingBalance, Role: RoleEmployee}
+
+	err = h.db.WithTx(ctx, func(tx *sqlx.Tx) error {
+		err := tx.QueryRow(`
+			INSERT INTO users (name, pass, coins) VALUES ($1, $2, 0) RETURNING id`,
+			user.Name, user.Pass).Scan(&user.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := ledger.OpenAccount(tx, user.ID); err != nil {
+			return err
+		}
+
+		if user.Coins > 0 {
+			_, err = ledger.Grant(tx, user.ID, user.Coins, "starting balance")
+		}
+		return err
+	})
+	if db.IsUniqueViolation(err) {
+		return User{}, errUserExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
 }
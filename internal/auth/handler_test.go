@@ -0,0 +1,452 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jamsi-max/merch-store/config"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestServer(mockDB *sql.DB, cfg *config.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	sqlxDB := sqlx.NewDb(mockDB, "postgres")
+	database := &db.Database{DB: sqlxDB}
+	authHandler := NewAuthHandler(database, cfg, NewHMACKeyRing(cfg.JWTSecret), NewRevocationList(database))
+
+	r.POST("/api/auth", authHandler.Auth)
+	r.POST("/api/auth/refresh", authHandler.Refresh)
+	r.POST("/api/register", authHandler.Register)
+	return r
+}
+
+func postJSON(t *testing.T, server *gin.Engine, path string, body map[string]interface{}) *httptest.ResponseRecorder {
+	payload, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(payload))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func expectRefreshTokenInsert(mock sqlmock.Sqlmock, userID int) {
+	mock.ExpectExec(`INSERT INTO refresh_tokens \(user_id, token_hash, expires_at\) VALUES \(\$1, \$2, \$3\)`).
+		WithArgs(userID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func expectCreateUser(mock sqlmock.Sqlmock, name string, userID, coins int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users \(name, pass, coins\) VALUES \(\$1, \$2, 0\) RETURNING id`).
+		WithArgs(name, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs(fmt.Sprintf("user:%d", userID), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO ledger_txns \(kind, reference\) VALUES \(\$1, \$2\) RETURNING id`).
+		WithArgs("grant", "starting balance").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(1, "system:issuance", -coins).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs(fmt.Sprintf("user:%d", userID), userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(1, fmt.Sprintf("user:%d", userID), coins).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(coins, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestRegister(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	server := setupTestServer(mockDB, &config.Config{JWTSecret: "testsecret", StartingBalance: 500})
+
+	tests := []struct {
+		name           string
+		body           map[string]interface{}
+		setupMock      func()
+		expectedStatus int
+	}{
+		{
+			name: "Successful registration",
+			body: map[string]interface{}{"username": "new_user", "password": "secret123"},
+			setupMock: func() {
+				expectCreateUser(mock, "new_user", 7, 500)
+				expectRefreshTokenInsert(mock, 7)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "Username already taken",
+			body: map[string]interface{}{"username": "new_user", "password": "secret123"},
+			setupMock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO users`).
+					WithArgs("new_user", sqlmock.AnyArg()).
+					WillReturnError(&pq.Error{Code: "23505"})
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Invalid username",
+			body:           map[string]interface{}{"username": "a!", "password": "secret123"},
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Reserved username",
+			body:           map[string]interface{}{"username": "System", "password": "secret123"},
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Password too short",
+			body:           map[string]interface{}{"username": "new_user", "password": "123"},
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock()
+
+			w := postJSON(t, server, "/api/register", tt.body)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	hashedPassword, err := HashPassword("secret123")
+	require.NoError(t, err)
+
+	userColumns := []string{"id", "name", "pass", "coins", "role"}
+
+	tests := []struct {
+		name           string
+		autoSignup     bool
+		body           map[string]interface{}
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Existing user logs in",
+			body: map[string]interface{}{"username": "user", "password": "secret123"},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, pass, coins, role FROM users WHERE name=\$1`).
+					WithArgs("user").
+					WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "user", hashedPassword, 1000, RoleEmployee))
+				expectRefreshTokenInsert(mock, 1)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Wrong password",
+			body: map[string]interface{}{"username": "user", "password": "wrong-pass"},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, pass, coins, role FROM users WHERE name=\$1`).
+					WithArgs("user").
+					WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "user", hashedPassword, 1000, RoleEmployee))
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Unknown user is not created",
+			body: map[string]interface{}{"username": "typo", "password": "secret123"},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, pass, coins, role FROM users WHERE name=\$1`).
+					WithArgs("typo").
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Database error does not create user",
+			body: map[string]interface{}{"username": "user", "password": "secret123"},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, pass, coins, role FROM users WHERE name=\$1`).
+					WithArgs("user").
+					WillReturnError(errors.New("connection reset"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "Legacy auto-signup",
+			autoSignup: true,
+			body:       map[string]interface{}{"username": "demo", "password": "secret123"},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, pass, coins, role FROM users WHERE name=\$1`).
+					WithArgs("demo").
+					WillReturnError(sql.ErrNoRows)
+				expectCreateUser(mock, "demo", 3, 1000)
+				expectRefreshTokenInsert(mock, 3)
+			},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			server := setupTestServer(mockDB, &config.Config{
+				JWTSecret:       "testsecret",
+				StartingBalance: 1000,
+				AuthAutoSignup:  tt.autoSignup,
+				AccessTokenTTL:  time.Minute,
+				RefreshTokenTTL: time.Hour,
+			})
+			tt.setupMock(mock)
+
+			w := postJSON(t, server, "/api/auth", tt.body)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tokenColumns := []string{"id", "user_id", "name", "role", "expires_at", "revoked_at"}
+	revokedAt := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name           string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Token is rotated",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT rt.id, rt.user_id, u.name, u.role, rt.expires_at, rt.revoked_at FROM refresh_tokens rt`).
+					WithArgs(hashRefreshToken("refresh-token")).
+					WillReturnRows(sqlmock.NewRows(tokenColumns).AddRow(5, 1, "user", RoleEmployee, time.Now().Add(time.Hour), nil))
+				mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = now\(\) WHERE id = \$1`).
+					WithArgs(5).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRefreshTokenInsert(mock, 1)
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Unknown token",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT rt.id, rt.user_id, u.name, u.role, rt.expires_at, rt.revoked_at FROM refresh_tokens rt`).
+					WithArgs(hashRefreshToken("refresh-token")).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Expired token",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT rt.id, rt.user_id, u.name, u.role, rt.expires_at, rt.revoked_at FROM refresh_tokens rt`).
+					WithArgs(hashRefreshToken("refresh-token")).
+					WillReturnRows(sqlmock.NewRows(tokenColumns).AddRow(5, 1, "user", RoleEmployee, time.Now().Add(-time.Hour), nil))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Reused token revokes every session",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT rt.id, rt.user_id, u.name, u.role, rt.expires_at, rt.revoked_at FROM refresh_tokens rt`).
+					WithArgs(hashRefreshToken("refresh-token")).
+					WillReturnRows(sqlmock.NewRows(tokenColumns).AddRow(5, 1, "user", RoleEmployee, time.Now().Add(time.Hour), revokedAt))
+				mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = now\(\) WHERE user_id = \$1 AND revoked_at IS NULL`).
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			server := setupTestServer(mockDB, &config.Config{
+				JWTSecret:       "testsecret",
+				AccessTokenTTL:  time.Minute,
+				RefreshTokenTTL: time.Hour,
+			})
+			tt.setupMock(mock)
+
+			w := postJSON(t, server, "/api/auth/refresh", map[string]interface{}{"refreshToken": "refresh-token"})
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthMiddleware_RevokedToken(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	keys := NewHMACKeyRing("testsecret")
+	revocations := NewRevocationList(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.GET("/api/info", AuthMiddleware(keys, revocations), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	token, err := GenerateToken(keys, 1, "user", RoleEmployee, time.Minute)
+	require.NoError(t, err)
+
+	claims := &Claims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+
+	request := func() int {
+		req, err := http.NewRequest(http.MethodGet, "/api/info", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request())
+
+	mock.ExpectExec(`INSERT INTO revoked_tokens \(jti, expires_at\) VALUES \(\$1, \$2\)`).
+		WithArgs(claims.ID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, revocations.Revoke(context.Background(), claims.ID, claims.ExpiresAt.Time))
+
+	assert.Equal(t, http.StatusUnauthorized, request())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLogout_OptionalBody(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	database := &db.Database{DB: sqlx.NewDb(mockDB, "postgres")}
+	authHandler := NewAuthHandler(database, &config.Config{}, NewHMACKeyRing("testsecret"), NewRevocationList(database))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/logout", func(c *gin.Context) {
+		c.Set("userID", 1)
+		authHandler.Logout(c)
+	})
+
+	tests := []struct {
+		name           string
+		body           string
+		chunked        bool
+		expectedStatus int
+	}{
+		{name: "No body", expectedStatus: http.StatusOK},
+		{name: "Chunked empty body", chunked: true, expectedStatus: http.StatusOK},
+		{name: "Chunked body", body: `{"refreshToken": "abc"}`, chunked: true, expectedStatus: http.StatusOK},
+		{name: "Malformed body", body: `{"all": `, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.body != "" && tt.expectedStatus == http.StatusOK {
+				mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = now\(\)`).
+					WithArgs(hashRefreshToken("abc"), 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/logout", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequireRole(t *testing.T) {
+	keys := NewHMACKeyRing("testsecret")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.GET("/api/admin/ping", AuthMiddleware(keys, nil), RequireRole(RoleAdmin), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		role           string
+		expectedStatus int
+	}{
+		{role: RoleAdmin, expectedStatus: http.StatusOK},
+		{role: RoleManager, expectedStatus: http.StatusForbidden},
+		{role: RoleEmployee, expectedStatus: http.StatusForbidden},
+		{role: "", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run("role "+tt.role, func(t *testing.T) {
+			token, err := GenerateToken(keys, 1, "user", tt.role, time.Minute)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodGet, "/api/admin/ping", nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyKeyID is used for HS256 keys built from JWT_SECRET and for tokens
+// issued before kid headers were introduced.
+const legacyKeyID = "default"
+
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+type KeyRing struct {
+	keys    map[string]*signingKey
+	current *signingKey
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewHMACKeyRing(secret string) *KeyRing {
+	key := &signingKey{
+		id:      legacyKeyID,
+		method:  jwt.SigningMethodHS256,
+		private: []byte(secret),
+		public:  []byte(secret),
+	}
+
+	return &KeyRing{keys: map[string]*signingKey{key.id: key}, current: key}
+}
+
+// LoadKeyRing reads every key in dir: "<kid>.pem" files hold RSA or Ed25519
+// private keys, "<kid>.key" files hold HS256 secrets. All of them verify
+// tokens, only signingKeyID signs new ones. Without a directory the ring
+// falls back to the single JWT_SECRET key. With one, JWT_SECRET still
+// verifies legacyKeyID tokens issued before rotation was turned on, unless
+// dir has a key of that id itself.
+func LoadKeyRing(dir, signingKeyID, legacySecret string) (*KeyRing, error) {
+	if dir == "" {
+		return NewHMACKeyRing(legacySecret), nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &KeyRing{keys: make(map[string]*signingKey)}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		ext := filepath.Ext(entry.Name())
+		if ext != ".pem" && ext != ".key" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		id := strings.TrimSuffix(entry.Name(), ext)
+		key, err := parseSigningKey(id, ext, data)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", entry.Name(), err)
+		}
+		ring.keys[id] = key
+	}
+
+	if len(ring.keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found in %s", dir)
+	}
+
+	if signingKeyID == "" && len(ring.keys) == 1 {
+		for id := range ring.keys {
+			signingKeyID = id
+		}
+	}
+
+	current, ok := ring.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found in %s", signingKeyID, dir)
+	}
+	ring.current = current
+
+	if _, ok := ring.keys[legacyKeyID]; !ok && legacySecret != "" {
+		ring.keys[legacyKeyID] = NewHMACKeyRing(legacySecret).current
+	}
+
+	return ring, nil
+}
+
+func parseSigningKey(id, ext string, data []byte) (*signingKey, error) {
+	if ext == ".key" {
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) == 0 {
+			return nil, errors.New("empty secret")
+		}
+		return &signingKey{id: id, method: jwt.SigningMethodHS256, private: secret, public: secret}, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+
+	var private crypto.PrivateKey
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{id: id, method: jwt.SigningMethodRS256, private: key, public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{id: id, method: jwt.SigningMethodEdDSA, private: key, public: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+}
+
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(r.current.method, claims)
+	token.Header["kid"] = r.current.id
+	return token.SignedString(r.current.private)
+}
+
+func (r *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = legacyKeyID
+	}
+
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+
+	return key.public, nil
+}
+
+// JWKS publishes only asymmetric keys: HS256 secrets must never leave the service.
+func (r *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range r.keys {
+		jwk := JWK{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePrivateKey(t *testing.T, dir, name string, key interface{}) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+}
+
+func setupKeysDir(t *testing.T) string {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePrivateKey(t, dir, "2025-01.pem", rsaKey)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	writePrivateKey(t, dir, "2025-02.pem", edKey)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "default.key"), []byte("testsecret\n"), 0o600))
+
+	return dir
+}
+
+func parseToken(t *testing.T, keys *KeyRing, token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, keys.Keyfunc)
+	return claims, err
+}
+
+func TestKeyRing_SignAndVerify(t *testing.T) {
+	dir := setupKeysDir(t)
+
+	for _, kid := range []string{"2025-01", "2025-02", "default"} {
+		t.Run(kid, func(t *testing.T) {
+			keys, err := LoadKeyRing(dir, kid, "")
+			require.NoError(t, err)
+
+			token, err := GenerateToken(keys, 1, "user", RoleEmployee, time.Minute)
+			require.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			require.NoError(t, err)
+			assert.Equal(t, kid, parsed.Header["kid"])
+
+			claims, err := parseToken(t, keys, token)
+			require.NoError(t, err)
+			assert.Equal(t, 1, claims.UserID)
+		})
+	}
+}
+
+func TestKeyRing_Rotation(t *testing.T) {
+	dir := setupKeysDir(t)
+
+	oldRing, err := LoadKeyRing(dir, "2025-01", "")
+	require.NoError(t, err)
+	oldToken, err := GenerateToken(oldRing, 1, "user", RoleEmployee, time.Minute)
+	require.NoError(t, err)
+
+	newRing, err := LoadKeyRing(dir, "2025-02", "")
+	require.NoError(t, err)
+
+	_, err = parseToken(t, newRing, oldToken)
+	assert.NoError(t, err, "tokens signed by a retired signing key stay valid while the key is in the ring")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "2025-01.pem")))
+	prunedRing, err := LoadKeyRing(dir, "2025-02", "")
+	require.NoError(t, err)
+
+	_, err = parseToken(t, prunedRing, oldToken)
+	assert.Error(t, err)
+}
+
+func TestKeyRing_LegacyTokenWithoutKid(t *testing.T) {
+	keys := NewHMACKeyRing("testsecret")
+
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 1,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	token, err := legacy.SignedString([]byte("testsecret"))
+	require.NoError(t, err)
+
+	claims, err := parseToken(t, keys, token)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claims.UserID)
+}
+
+func TestKeyRing_LegacySecretAfterRotation(t *testing.T) {
+	before, err := GenerateToken(NewHMACKeyRing("oldsecret"), 1, "user", RoleEmployee, time.Minute)
+	require.NoError(t, err)
+
+	dir := setupKeysDir(t)
+	require.NoError(t, os.Remove(filepath.Join(dir, "default.key")))
+
+	keys, err := LoadKeyRing(dir, "2025-02", "oldsecret")
+	require.NoError(t, err)
+
+	claims, err := parseToken(t, keys, before)
+	require.NoError(t, err, "tokens signed with JWT_SECRET stay valid once JWT_KEYS_DIR is set")
+	assert.Equal(t, 1, claims.UserID)
+
+	_, err = LoadKeyRing(dir, legacyKeyID, "oldsecret")
+	assert.Error(t, err, "JWT_SECRET only verifies, it never signs from a key directory")
+
+	// A default.key in the directory replaces JWT_SECRET.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "default.key"), []byte("newsecret"), 0o600))
+	keys, err = LoadKeyRing(dir, "2025-02", "oldsecret")
+	require.NoError(t, err)
+
+	_, err = parseToken(t, keys, before)
+	assert.Error(t, err)
+}
+
+func TestKeyRing_RejectsAlgorithmMismatch(t *testing.T) {
+	dir := setupKeysDir(t)
+	keys, err := LoadKeyRing(dir, "2025-01", "")
+	require.NoError(t, err)
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 1})
+	forged.Header["kid"] = "2025-01"
+	token, err := forged.SignedString([]byte("whatever"))
+	require.NoError(t, err)
+
+	_, err = parseToken(t, keys, token)
+	assert.Error(t, err)
+}
+
+func TestKeyRing_JWKSExcludesSecrets(t *testing.T) {
+	keys, err := LoadKeyRing(setupKeysDir(t), "2025-01", "")
+	require.NoError(t, err)
+
+	set := keys.JWKS()
+	require.Len(t, set.Keys, 2)
+
+	assert.Equal(t, "2025-01", set.Keys[0].Kid)
+	assert.Equal(t, "RSA", set.Keys[0].Kty)
+	assert.Equal(t, "RS256", set.Keys[0].Alg)
+	assert.NotEmpty(t, set.Keys[0].N)
+
+	assert.Equal(t, "2025-02", set.Keys[1].Kid)
+	assert.Equal(t, "OKP", set.Keys[1].Kty)
+	assert.Equal(t, "Ed25519", set.Keys[1].Crv)
+	assert.NotEmpty(t, set.Keys[1].X)
+}
+
+func TestLoadKeyRing_UnknownSigningKey(t *testing.T) {
+	_, err := LoadKeyRing(setupKeysDir(t), "missing", "")
+	assert.Error(t, err)
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/jamsi-max/merch-store/utils"
 )
 
-func AuthMiddleware(secret string) gin.HandlerFunc {
+func AuthMiddleware(keys *KeyRing, revocations *RevocationList) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -21,9 +21,7 @@ func AuthMiddleware(secret string) gin.HandlerFunc {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		claims := &Claims{}
 
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(_ *jwt.Token) (interface{}, error) {
-			return []byte(secret), nil
-		})
+		token, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc)
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -31,8 +29,50 @@ func AuthMiddleware(secret string) gin.HandlerFunc {
 			return
 		}
 
+		if revocations != nil && claims.ID != "" && revocations.IsRevoked(claims.ID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("role", claimsRole(claims))
+		c.Set("jti", claims.ID)
+		if claims.ExpiresAt != nil {
+			c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		}
 		c.Next()
 	}
 }
+
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		utils.ErrorResponse(c, http.StatusForbidden, "Forbidden")
+		c.Abort()
+	}
+}
+
+// claimsRole treats tokens issued before roles existed as employee tokens.
+func claimsRole(claims *Claims) string {
+	if claims.Role == "" {
+		return RoleEmployee
+	}
+
+	return claims.Role
+}
+
+func JWKSHandler(keys *KeyRing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, keys.JWKS())
+	}
+}
@@ -1,8 +1,24 @@
 package auth
 
+const (
+	RoleEmployee = "employee"
+	RoleManager  = "manager"
+	RoleAdmin    = "admin"
+)
+
 type User struct {
 	ID    int    `db:"id"`
 	Name  string `db:"name"`
 	Pass  string `db:"pass"`
 	Coins int    `db:"coins"`
+	Role  string `db:"role"`
+}
+
+func ValidRole(role string) bool {
+	switch role {
+	case RoleEmployee, RoleManager, RoleAdmin:
+		return true
+	}
+
+	return false
 }
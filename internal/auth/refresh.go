@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type refreshToken struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	Username  string     `db:"name"`
+	Role      string     `db:"role"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func storeRefreshToken(exec sqlx.Execer, userID int, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = exec.Exec(`
+		INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userID, hashRefreshToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func revokeRefreshToken(exec sqlx.Execer, id int) error {
+	_, err := exec.Exec("UPDATE refresh_tokens SET revoked_at = now() WHERE id = $1", id)
+	return err
+}
+
+func revokeUserRefreshTokens(exec sqlx.Execer, userID int) error {
+	_, err := exec.Exec(`
+		UPDATE refresh_tokens SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jamsi-max/merch-store/internal/db"
+)
+
+// RevocationList keeps revoked access token IDs in memory so AuthMiddleware
+// never hits the database. Revocations made by other replicas are picked up
+// on the next Sync.
+type RevocationList struct {
+	db      *db.Database
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+func NewRevocationList(db *db.Database) *RevocationList {
+	return &RevocationList{db: db, revoked: make(map[string]time.Time)}
+}
+
+func (r *RevocationList) IsRevoked(jti string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.revoked[jti]
+	return ok
+}
+
+func (r *RevocationList) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := r.db.DB.ExecContext(ctx, `
+		INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`, jti, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.revoked[jti] = expiresAt
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *RevocationList) Sync(ctx context.Context) error {
+	if _, err := r.db.DB.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < now()"); err != nil {
+		return err
+	}
+
+	rows, err := r.db.DB.QueryContext(ctx, "SELECT jti, expires_at FROM revoked_tokens")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return err
+		}
+		revoked[jti] = expiresAt
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for jti, expiresAt := range r.revoked {
+		if expiresAt.Before(now) {
+			delete(r.revoked, jti)
+		}
+	}
+	for jti, expiresAt := range revoked {
+		r.revoked[jti] = expiresAt
+	}
+
+	return nil
+}
+
+func (r *RevocationList) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("[ERR] failed to sync revoked tokens: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 6
+)
+
+// SystemName stands for the other side of coin history rows that the
+// system pays or takes, so no user may register under it.
+const SystemName = "system"
+
+var usernamePattern = regexp.MustCompile(`^[\p{L}\p{N}_.\- ]+$`)
+
+func validateUsername(name string) error {
+	length := utf8.RuneCountInString(name)
+	switch {
+	case length < minUsernameLength || length > maxUsernameLength:
+		return errors.New("must be between 3 and 32 characters")
+	case strings.TrimSpace(name) != name:
+		return errors.New("must not start or end with a space")
+	case !usernamePattern.MatchString(name):
+		return errors.New("may contain only letters, digits, spaces, '.', '_' and '-'")
+	case strings.EqualFold(name, SystemName):
+		return errors.New("is reserved")
+	}
+
+	return nil
+}
+
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return errors.New("must be at least 6 characters")
+	}
+
+	return nil
+}
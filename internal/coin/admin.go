@@ -0,0 +1,181 @@
+package coin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jamsi-max/merch-store/internal/ledger"
+	"github.com/jamsi-max/merch-store/internal/memo"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	AdjustmentGrant     = "grant"
+	AdjustmentDeduction = "deduction"
+
+	adjustmentsEndpoint = "adjustments"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+type AdjustmentRequest struct {
+	Kind   string   `json:"kind" binding:"required,oneof=grant deduction"`
+	Users  []string `json:"users" binding:"required,min=1,max=500,dive,required"`
+	Amount int      `json:"amount" binding:"required,min=1"`
+	Reason string   `json:"reason" binding:"required"`
+}
+
+type AdjustmentResult struct {
+	User    string `json:"user"`
+	Balance int    `json:"balance"`
+}
+
+type userBalance struct {
+	ID    int    `db:"id"`
+	Name  string `db:"name"`
+	Coins int    `db:"coins"`
+}
+
+// AdjustBalances grants coins to or deducts them from a list of users in one
+// transaction: either every user is adjusted or none is. Each adjustment
+// shows in the user's coin history as coming from or going to the system.
+func (h *CoinHandler) AdjustBalances(c *gin.Context) {
+	adminID := c.GetInt("userID")
+
+	var req AdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	req.Reason = memo.Sanitize(req.Reason)
+	switch {
+	case req.Reason == "":
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Reason is required"})
+		return
+	case utf8.RuneCountInString(req.Reason) > memo.MaxLength:
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Reason is too long"})
+		return
+	}
+
+	seen := make(map[string]bool, len(req.Users))
+	for _, name := range req.Users {
+		if seen[name] {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Duplicate user: " + name})
+			return
+		}
+		seen[name] = true
+	}
+
+	idempotencyKey, err := idempotency.KeyFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid Idempotency-Key"})
+		return
+	}
+	idempotent, done := h.idempotency.Begin(c, idempotencyKey, adjustmentsEndpoint, req)
+	if done {
+		return
+	}
+
+	var (
+		failedUser string
+		body       []byte
+	)
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		if err := idempotent.Claim(tx); err != nil {
+			return err
+		}
+
+		// Rows are locked in id order, like lockBalances, so a bulk
+		// adjustment cannot deadlock with transfers between the same users.
+		var balances []userBalance
+		err := tx.Select(&balances, `
+			SELECT id, name, coins FROM users WHERE name = ANY($1) ORDER BY id FOR UPDATE`,
+			pq.Array(req.Users))
+		if err != nil {
+			return err
+		}
+
+		byName := make(map[string]userBalance, len(balances))
+		for _, balance := range balances {
+			byName[balance.Name] = balance
+		}
+
+		// Every user is checked before anything is posted, so the error
+		// names the first user that blocks the whole batch.
+		results := make([]AdjustmentResult, 0, len(req.Users))
+		for _, name := range req.Users {
+			balance, ok := byName[name]
+			if !ok {
+				failedUser = name
+				return errUserNotFound
+			}
+
+			coins := balance.Coins + req.Amount
+			if req.Kind == AdjustmentDeduction {
+				coins = balance.Coins - req.Amount
+			}
+			if coins < 0 {
+				failedUser = name
+				return errInsufficientFunds
+			}
+			results = append(results, AdjustmentResult{User: name, Balance: coins})
+		}
+
+		for _, name := range req.Users {
+			if err := adjustBalance(tx, adminID, byName[name].ID, req); err != nil {
+				return err
+			}
+		}
+
+		body, err = json.Marshal(gin.H{"kind": req.Kind, "amount": req.Amount, "reason": req.Reason, "users": results})
+		if err != nil {
+			return err
+		}
+
+		return idempotent.Save(tx, http.StatusOK, body)
+	})
+
+	switch {
+	case err == nil:
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	case errors.Is(err, errUserNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "User not found: " + failedUser})
+	case errors.Is(err, errInsufficientFunds):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Insufficient funds: " + failedUser})
+	case idempotent.Finish(c, err):
+	default:
+		log.Printf("[ERR] balance adjustment failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Balance adjustment failed"})
+	}
+}
+
+// adjustBalance posts one grant or deduction to the ledger and records it in
+// the coin history with the system on the other side.
+func adjustBalance(tx *sqlx.Tx, adminID, userID int, req AdjustmentRequest) error {
+	var (
+		senderID, receiverID *int
+		err                  error
+	)
+	if req.Kind == AdjustmentGrant {
+		receiverID = &userID
+		_, err = ledger.Grant(tx, userID, req.Amount, req.Reason)
+	} else {
+		senderID = &userID
+		_, err = ledger.Deduct(tx, userID, req.Amount, req.Reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO transactions (sender_id, receiver_id, amount, message, kind, actor_id) VALUES ($1, $2, $3, $4, $5, $6)`,
+		senderID, receiverID, req.Amount, req.Reason, req.Kind, adminID)
+	return err
+}
@@ -0,0 +1,165 @@
+package coin
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAdjustmentServer(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	database := &db.Database{DB: sqlx.NewDb(mockDB, "postgres")}
+	handler := NewCoinHandler(database, idempotency.NewStore(database, time.Hour))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/admin/coins/adjustments", setUserIDMiddleware(99), handler.AdjustBalances)
+
+	return r, mock
+}
+
+func postAdjustment(r *gin.Engine, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/coins/adjustments", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func expectLockUsers(mock sqlmock.Sqlmock, names []string, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT id, name, coins FROM users WHERE name = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(pq.Array(names)).
+		WillReturnRows(rows)
+}
+
+func expectAdjustment(mock sqlmock.Sqlmock, kind string, txnID, userID, amount int, reason string) {
+	account := "user:" + strconv.Itoa(userID)
+	userAmount, issuanceAmount := amount, -amount
+	sender, receiver := interface{}(nil), interface{}(userID)
+	if kind == AdjustmentDeduction {
+		userAmount, issuanceAmount = -amount, amount
+		sender, receiver = userID, nil
+	}
+
+	mock.ExpectQuery(`INSERT INTO ledger_txns`).
+		WithArgs(kind, reason).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(txnID))
+	if kind == AdjustmentGrant {
+		mock.ExpectExec(`INSERT INTO ledger_entries`).
+			WithArgs(txnID, "system:issuance", issuanceAmount).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs(account, userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(txnID, account, userAmount).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(userAmount, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if kind == AdjustmentDeduction {
+		mock.ExpectExec(`INSERT INTO ledger_entries`).
+			WithArgs(txnID, "system:issuance", issuanceAmount).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectExec(`INSERT INTO transactions \(sender_id, receiver_id, amount, message, kind, actor_id\)`).
+		WithArgs(sender, receiver, amount, reason, kind, 99).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestAdjustBalances_Grant(t *testing.T) {
+	r, mock := setupAdjustmentServer(t)
+
+	mock.ExpectBegin()
+	expectLockUsers(mock, []string{"bob", "alice"}, sqlmock.NewRows([]string{"id", "name", "coins"}).
+		AddRow(1, "alice", 100).
+		AddRow(2, "bob", 0))
+	expectAdjustment(mock, AdjustmentGrant, 7, 2, 50, "Hackathon winners")
+	expectAdjustment(mock, AdjustmentGrant, 8, 1, 50, "Hackathon winners")
+	mock.ExpectCommit()
+
+	w := postAdjustment(r, `{"kind": "grant", "users": ["bob", "alice"], "amount": 50, "reason": " Hackathon winners "}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"kind": "grant",
+		"amount": 50,
+		"reason": "Hackathon winners",
+		"users": [{"user": "bob", "balance": 50}, {"user": "alice", "balance": 150}]
+	}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdjustBalances_Deduction(t *testing.T) {
+	r, mock := setupAdjustmentServer(t)
+
+	mock.ExpectBegin()
+	expectLockUsers(mock, []string{"alice"}, sqlmock.NewRows([]string{"id", "name", "coins"}).AddRow(1, "alice", 100))
+	expectAdjustment(mock, AdjustmentDeduction, 7, 1, 30, "Duplicate bonus")
+	mock.ExpectCommit()
+
+	w := postAdjustment(r, `{"kind": "deduction", "users": ["alice"], "amount": 30, "reason": "Duplicate bonus"}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `{"user":"alice","balance":70}`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdjustBalances_Rejected(t *testing.T) {
+	r, mock := setupAdjustmentServer(t)
+
+	tests := []struct {
+		name         string
+		body         string
+		expectedBody string
+	}{
+		{name: "Unknown kind", body: `{"kind": "bonus", "users": ["alice"], "amount": 10, "reason": "x"}`, expectedBody: `{"errors": "Invalid request"}`},
+		{name: "No users", body: `{"kind": "grant", "users": [], "amount": 10, "reason": "x"}`, expectedBody: `{"errors": "Invalid request"}`},
+		{name: "Missing reason", body: `{"kind": "grant", "users": ["alice"], "amount": 10}`, expectedBody: `{"errors": "Invalid request"}`},
+		{name: "Blank reason", body: `{"kind": "grant", "users": ["alice"], "amount": 10, "reason": " ​ "}`, expectedBody: `{"errors": "Reason is required"}`},
+		{name: "Duplicate user", body: `{"kind": "grant", "users": ["alice", "alice"], "amount": 10, "reason": "x"}`, expectedBody: `{"errors": "Duplicate user: alice"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postAdjustment(r, tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+
+	mock.ExpectBegin()
+	expectLockUsers(mock, []string{"alice", "ghost"}, sqlmock.NewRows([]string{"id", "name", "coins"}).AddRow(1, "alice", 100))
+	mock.ExpectRollback()
+
+	w := postAdjustment(r, `{"kind": "grant", "users": ["alice", "ghost"], "amount": 10, "reason": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "User not found: ghost"}`, w.Body.String())
+
+	mock.ExpectBegin()
+	expectLockUsers(mock, []string{"alice", "bob"}, sqlmock.NewRows([]string{"id", "name", "coins"}).
+		AddRow(1, "alice", 100).
+		AddRow(2, "bob", 5))
+	mock.ExpectRollback()
+
+	w = postAdjustment(r, `{"kind": "deduction", "users": ["alice", "bob"], "amount": 10, "reason": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Insufficient funds: bob"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
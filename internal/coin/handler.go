@@ -1,25 +1,43 @@
 package coin
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jamsi-max/merch-store/internal/ledger"
+	"github.com/jamsi-max/merch-store/internal/memo"
+	"github.com/jmoiron/sqlx"
 )
 
+const sendCoinEndpoint = "sendCoin"
+
+var errInsufficientFunds = errors.New("insufficient funds")
+
 type CoinHandler struct {
-	db *db.Database
+	db          *db.Database
+	idempotency *idempotency.Store
 }
 
-func NewCoinHandler(db *db.Database) *CoinHandler {
-	return &CoinHandler{db: db}
+func NewCoinHandler(db *db.Database, idempotency *idempotency.Store) *CoinHandler {
+	return &CoinHandler{db: db, idempotency: idempotency}
+}
+
+type accountBalance struct {
+	ID    int `db:"id"`
+	Coins int `db:"coins"`
 }
 
 func (h *CoinHandler) SendCoin(c *gin.Context) {
 	var req struct {
-		ToUser string `json:"toUser" binding:"required"`
-		Amount int    `json:"amount" binding:"required,min=1"`
+		ToUser  string `json:"toUser" binding:"required"`
+		Amount  int    `json:"amount" binding:"required,min=1"`
+		Message string `json:"message,omitempty"`
+		Tag     string `json:"tag,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -27,70 +45,91 @@ func (h *CoinHandler) SendCoin(c *gin.Context) {
 		return
 	}
 
-	fromUserID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Unauthorized"})
+	req.Message = memo.Sanitize(req.Message)
+	if utf8.RuneCountInString(req.Message) > memo.MaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Message is too long"})
 		return
 	}
 
-	var toUserID int
-	err := h.db.DB.Get(&toUserID, "SELECT id FROM users WHERE name=$1", req.ToUser)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"errors": "Recipient not found"})
+	if req.Tag != "" && !memo.ValidTag(req.Tag) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid tag"})
 		return
 	}
 
-	var senderCoins int
-	err = h.db.DB.Get(&senderCoins, "SELECT coins FROM users WHERE id=$1", fromUserID)
-	if err != nil || senderCoins < req.Amount {
-		c.JSON(http.StatusBadRequest, gin.H{"errors": "Insufficient funds"})
+	if _, exists := c.Get("userID"); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Unauthorized"})
 		return
 	}
+	fromUserID := c.GetInt("userID")
 
-	tx, err := h.db.DB.Begin()
+	idempotencyKey, err := idempotency.KeyFromRequest(c)
 	if err != nil {
-		log.Printf("[ERR] transaction coin failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Transaction coin failed"})
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid Idempotency-Key"})
+		return
+	}
+	idempotent, done := h.idempotency.Begin(c, idempotencyKey, sendCoinEndpoint, req)
+	if done {
 		return
 	}
 
-	_, err = tx.Exec("UPDATE users SET coins = coins - $1 WHERE id = $2", req.Amount, fromUserID)
+	var toUserID int
+	err = h.db.DB.Get(&toUserID, "SELECT id FROM users WHERE name=$1", req.ToUser)
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			log.Printf("[ERR] failed to rollback transaction: %v", rbErr)
-		}
-		log.Printf("[ERR] failed to update sender balance: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to update sender balance"})
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Recipient not found"})
 		return
 	}
 
-	_, err = tx.Exec("UPDATE users SET coins = coins + $1 WHERE id = $2", req.Amount, toUserID)
-	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			log.Printf("[ERR] failed to rollback transaction: %v", rbErr)
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		if err := idempotent.Claim(tx); err != nil {
+			return err
 		}
-		log.Printf("[ERR] failed to update recipient balance: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to update recipient balance"})
-		return
+
+		if err := lockBalances(tx, fromUserID, toUserID, req.Amount); err != nil {
+			return err
+		}
+
+		if _, err := ledger.Transfer(tx, fromUserID, toUserID, req.Amount); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO transactions (sender_id, receiver_id, amount, message, tag) VALUES ($1, $2, $3, $4, $5)`,
+			fromUserID, toUserID, req.Amount, req.Message, req.Tag)
+		if err != nil {
+			return err
+		}
+
+		return idempotent.Save(tx, http.StatusOK, nil)
+	})
+
+	switch {
+	case err == nil:
+		c.Status(http.StatusOK)
+	case errors.Is(err, errInsufficientFunds), db.IsCheckViolation(err):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Insufficient funds"})
+	case idempotent.Finish(c, err):
+	default:
+		log.Printf("[ERR] transaction coin failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Transaction coin failed"})
 	}
+}
 
-	_, err = tx.Exec(`
-		INSERT INTO transactions (sender_id, receiver_id, amount) VALUES ($1, $2, $3)`,
-		fromUserID, toUserID, req.Amount)
+// lockBalances locks both rows in id order, so two opposite transfers between
+// the same users cannot deadlock, and checks the sender balance under the lock.
+func lockBalances(tx *sqlx.Tx, fromUserID, toUserID, amount int) error {
+	var balances []accountBalance
+	err := tx.Select(&balances, `
+		SELECT id, coins FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`,
+		fromUserID, toUserID)
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			log.Printf("[ERR] failed to rollback transaction: %v", rbErr)
-		}
-		log.Printf("[ERR] failed to record transaction: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to record transaction"})
-		return
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("[ERR] failed to commit transaction: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to commit transaction"})
-		return
+	for _, balance := range balances {
+		if balance.ID == fromUserID && balance.Coins >= amount {
+			return nil
+		}
 	}
 
-	c.Status(http.StatusOK)
+	return errInsufficientFunds
 }
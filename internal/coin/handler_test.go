@@ -6,12 +6,16 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,7 +26,7 @@ func setupTestServer(mockDB *sql.DB) *gin.Engine {
 	sqlxDB := sqlx.NewDb(mockDB, "postgres")
 	database := &db.Database{DB: sqlxDB}
 
-	coinHandler := NewCoinHandler(database)
+	coinHandler := NewCoinHandler(database, idempotency.NewStore(database, time.Hour))
 
 	r.POST("/api/sendCoin", setUserIDMiddleware(1), coinHandler.SendCoin)
 	return r
@@ -35,6 +39,36 @@ func setUserIDMiddleware(userID int) gin.HandlerFunc {
 	}
 }
 
+func expectLockBalances(mock sqlmock.Sqlmock, senderCoins int) {
+	mock.ExpectQuery(`SELECT id, coins FROM users WHERE id IN \(\$1, \$2\) ORDER BY id FOR UPDATE`).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "coins"}).AddRow(1, senderCoins).AddRow(2, 1000))
+}
+
+func expectTransfer(mock sqlmock.Sqlmock, amount int) {
+	mock.ExpectQuery(`INSERT INTO ledger_txns \(kind, reference\) VALUES \(\$1, \$2\) RETURNING id`).
+		WithArgs("transfer", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	for _, entry := range []struct {
+		account        string
+		userID, amount int
+	}{
+		{account: "user:1", userID: 1, amount: -amount},
+		{account: "user:2", userID: 2, amount: amount},
+	} {
+		mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+			WithArgs(entry.account, entry.userID).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO ledger_entries \(txn_id, account, amount\) VALUES \(\$1, \$2, \$3\)`).
+			WithArgs(1, entry.account, entry.amount).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+			WithArgs(entry.amount, entry.userID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
 func TestSendCoin(t *testing.T) {
 	mockDB, mock, err := sqlmock.New()
 	if err != nil {
@@ -44,9 +78,16 @@ func TestSendCoin(t *testing.T) {
 
 	server := setupTestServer(mockDB)
 
+	requestHash := idempotency.Hash("sendCoin", struct {
+		ToUser string `json:"toUser"`
+		Amount int    `json:"amount"`
+	}{ToUser: "receiver", Amount: 100})
+	idempotencyColumns := []string{"request_hash", "status_code", "response_body"}
+
 	tests := []struct {
 		name           string
 		body           map[string]interface{}
+		idempotencyKey string
 		setupMock      func()
 		expectedStatus int
 	}{
@@ -59,22 +100,14 @@ func TestSendCoin(t *testing.T) {
 				recipientQuery.WithArgs("receiver").
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
 
-				balanceQuery := mock.ExpectQuery(`SELECT coins FROM users WHERE id=\$1`)
-				balanceQuery.WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(1000))
-
 				mock.ExpectBegin()
 
-				mock.ExpectExec(`UPDATE users SET coins = coins - \$1 WHERE id = \$2`).
-					WithArgs(100, 1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectLockBalances(mock, 1000)
 
-				mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
-					WithArgs(100, 2).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectTransfer(mock, 100)
 
-				mock.ExpectExec(`INSERT INTO transactions \(sender_id, receiver_id, amount\) VALUES \(\$1, \$2, \$3\)`).
-					WithArgs(1, 2, 100).
+				mock.ExpectExec(`INSERT INTO transactions \(sender_id, receiver_id, amount, message, tag\) VALUES \(\$1, \$2, \$3, \$4, \$5\)`).
+					WithArgs(1, 2, 100, "", "").
 					WillReturnResult(sqlmock.NewResult(1, 1))
 
 				mock.ExpectCommit()
@@ -89,12 +122,109 @@ func TestSendCoin(t *testing.T) {
 					WithArgs("receiver").
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
 
-				mock.ExpectQuery(`SELECT coins FROM users WHERE id=\$1`).
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(50))
+				mock.ExpectBegin()
+
+				expectLockBalances(mock, 50)
+
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Transfer with message and tag",
+			body: map[string]interface{}{"toUser": "receiver", "amount": 100, "message": "  Thanks for\nthe review!\u202e ", "tag": "thanks"},
+			setupMock: func() {
+				mock.ExpectQuery(`SELECT id FROM users WHERE name=\$1`).
+					WithArgs("receiver").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+				mock.ExpectBegin()
+
+				expectLockBalances(mock, 1000)
+
+				expectTransfer(mock, 100)
+
+				mock.ExpectExec(`INSERT INTO transactions`).
+					WithArgs(1, 2, 100, "Thanks for the review!", "thanks").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectCommit()
 			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown tag",
+			body:           map[string]interface{}{"toUser": "receiver", "amount": 100, "tag": "bribe"},
+			setupMock:      func() {},
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name:           "Message too long",
+			body:           map[string]interface{}{"toUser": "receiver", "amount": 100, "message": strings.Repeat("a", 281)},
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Check constraint violation",
+			body: map[string]interface{}{"toUser": "receiver", "amount": 100},
+			setupMock: func() {
+				mock.ExpectQuery(`SELECT id FROM users WHERE name=\$1`).
+					WithArgs("receiver").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+				mock.ExpectBegin()
+
+				expectLockBalances(mock, 1000)
+
+				mock.ExpectQuery(`INSERT INTO ledger_txns`).
+					WithArgs("transfer", "").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+				mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+					WithArgs("user:1", 1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`INSERT INTO ledger_entries`).
+					WithArgs(1, "user:1", -100).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+					WithArgs(-100, 1).
+					WillReturnError(&pq.Error{Code: "23514"})
+
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Deadlock is retried",
+			body: map[string]interface{}{"toUser": "receiver", "amount": 100},
+			setupMock: func() {
+				mock.ExpectQuery(`SELECT id FROM users WHERE name=\$1`).
+					WithArgs("receiver").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+				mock.ExpectBegin()
+
+				mock.ExpectQuery(`SELECT id, coins FROM users WHERE id IN \(\$1, \$2\) ORDER BY id FOR UPDATE`).
+					WithArgs(1, 2).
+					WillReturnError(&pq.Error{Code: "40P01"})
+
+				mock.ExpectRollback()
+
+				mock.ExpectBegin()
+
+				expectLockBalances(mock, 1000)
+
+				expectTransfer(mock, 100)
+
+				mock.ExpectExec(`INSERT INTO transactions`).
+					WithArgs(1, 2, 100, "", "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+		},
 		{
 			name: "Recipient not found",
 			body: map[string]interface{}{"toUser": "unknown", "amount": 100},
@@ -105,6 +235,67 @@ func TestSendCoin(t *testing.T) {
 			},
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name:           "First request with idempotency key",
+			body:           map[string]interface{}{"toUser": "receiver", "amount": 100},
+			idempotencyKey: "key-1",
+			setupMock: func() {
+				mock.ExpectQuery(`SELECT request_hash, status_code, response_body FROM idempotency_keys`).
+					WithArgs(1, "key-1", sqlmock.AnyArg()).
+					WillReturnError(sql.ErrNoRows)
+
+				mock.ExpectQuery(`SELECT id FROM users WHERE name=\$1`).
+					WithArgs("receiver").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+				mock.ExpectBegin()
+
+				mock.ExpectExec(`DELETE FROM idempotency_keys WHERE user_id = \$1 AND key = \$2`).
+					WithArgs(1, "key-1", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+
+				mock.ExpectExec(`INSERT INTO idempotency_keys \(user_id, key, endpoint, request_hash\)`).
+					WithArgs(1, "key-1", "sendCoin", requestHash).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				expectLockBalances(mock, 1000)
+
+				expectTransfer(mock, 100)
+
+				mock.ExpectExec(`INSERT INTO transactions \(sender_id, receiver_id, amount, message, tag\) VALUES \(\$1, \$2, \$3, \$4, \$5\)`).
+					WithArgs(1, 2, 100, "", "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectExec(`UPDATE idempotency_keys SET status_code = \$3, response_body = \$4`).
+					WithArgs(1, "key-1", http.StatusOK, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Retried request is replayed",
+			body:           map[string]interface{}{"toUser": "receiver", "amount": 100},
+			idempotencyKey: "key-1",
+			setupMock: func() {
+				mock.ExpectQuery(`SELECT request_hash, status_code, response_body FROM idempotency_keys`).
+					WithArgs(1, "key-1", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows(idempotencyColumns).AddRow(requestHash, http.StatusOK, nil))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Idempotency key reused for another transfer",
+			body:           map[string]interface{}{"toUser": "receiver", "amount": 500},
+			idempotencyKey: "key-1",
+			setupMock: func() {
+				mock.ExpectQuery(`SELECT request_hash, status_code, response_body FROM idempotency_keys`).
+					WithArgs(1, "key-1", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows(idempotencyColumns).AddRow(requestHash, http.StatusOK, nil))
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
 		{
 			name: "Invalid request body",
 			body: map[string]interface{}{"toUser": "", "amount": 0},
@@ -124,6 +315,9 @@ func TestSendCoin(t *testing.T) {
 			req, err := http.NewRequest("POST", "/api/sendCoin", bytes.NewBuffer(body))
 			assert.NoError(t, err)
 			req.Header.Set("Content-Type", "application/json")
+			if tt.idempotencyKey != "" {
+				req.Header.Set(idempotency.Header, tt.idempotencyKey)
+			}
 
 			w := httptest.NewRecorder()
 			server.ServeHTTP(w, req)
@@ -9,7 +9,8 @@ import (
 )
 
 type Database struct {
-	DB *sqlx.DB
+	DB  *sqlx.DB
+	DSN string
 }
 
 func NewDatabase(dsn string) (*Database, error) {
@@ -23,5 +24,11 @@ func NewDatabase(dsn string) (*Database, error) {
 	db.SetConnMaxLifetime(5 * time.Minute)
 
 	log.Println("[INF] connected to database")
-	return &Database{DB: db}, nil
+	return &Database{DB: db, DSN: dsn}, nil
+}
+
+func Rollback(tx interface{ Rollback() error }) {
+	if err := tx.Rollback(); err != nil {
+		log.Printf("[ERR] failed to rollback transaction: %v", err)
+	}
 }
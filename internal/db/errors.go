@@ -0,0 +1,31 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+const (
+	uniqueViolation      = "23505"
+	checkViolation       = "23514"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+func IsUniqueViolation(err error) bool {
+	return hasCode(err, uniqueViolation)
+}
+
+func IsCheckViolation(err error) bool {
+	return hasCode(err, checkViolation)
+}
+
+func isRetryable(err error) bool {
+	return hasCode(err, serializationFailure) || hasCode(err, deadlockDetected)
+}
+
+func hasCode(err error, code pq.ErrorCode) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	maxTxAttempts = 3
+	txRetryDelay  = 20 * time.Millisecond
+)
+
+// WithTx runs fn in a transaction and commits it. Serialization failures and
+// deadlocks are retried with a fresh transaction, so fn must not have side
+// effects outside tx.
+func (d *Database) WithTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := d.runTx(ctx, fn)
+		if err == nil || !isRetryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+
+		log.Printf("[WRN] retrying transaction (attempt %d): %v", attempt+1, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * txRetryDelay):
+		}
+	}
+}
+
+func (d *Database) runTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		Rollback(tx)
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,250 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	Header       = "Idempotency-Key"
+	maxKeyLength = 255
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid idempotency key")
+	ErrKeyReused  = errors.New("idempotency key reused with a different request")
+	ErrClaimed    = errors.New("idempotency key claimed by another request")
+)
+
+type Response struct {
+	RequestHash string `db:"request_hash"`
+	StatusCode  *int   `db:"status_code"`
+	Body        []byte `db:"response_body"`
+}
+
+// Store persists idempotency keys together with the response they produced.
+// Keys are claimed inside the caller's transaction, so a key is recorded if
+// and only if the money movement it guards is committed.
+type Store struct {
+	db  *db.Database
+	ttl time.Duration
+}
+
+func NewStore(db *db.Database, ttl time.Duration) *Store {
+	return &Store{db: db, ttl: ttl}
+}
+
+func KeyFromRequest(c *gin.Context) (string, error) {
+	key := c.GetHeader(Header)
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// CheckKey validates a key passed some other way than the header, e.g. in
+// a request body; an empty key is valid and means no idempotency.
+func CheckKey(key string) error {
+	if len(key) > maxKeyLength {
+		return ErrInvalidKey
+	}
+
+	for _, r := range key {
+		if r < 0x21 || r > 0x7e {
+			return ErrInvalidKey
+		}
+	}
+
+	return nil
+}
+
+func Hash(endpoint string, request interface{}) string {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		payload = []byte(err.Error())
+	}
+
+	sum := sha256.Sum256(append([]byte(endpoint+"\n"), payload...))
+	return hex.EncodeToString(sum[:])
+}
+
+// Lookup returns the stored response for a completed request, or nil when
+// the key has not been used within the retention window.
+func (s *Store) Lookup(userID int, key, requestHash string) (*Response, error) {
+	var resp Response
+	err := s.db.DB.Get(&resp, `
+		SELECT request_hash, status_code, response_body FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND created_at > $3`,
+		userID, key, time.Now().Add(-s.ttl))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.RequestHash != requestHash {
+		return nil, ErrKeyReused
+	}
+
+	if resp.StatusCode == nil {
+		return nil, nil
+	}
+
+	return &resp, nil
+}
+
+// Claim reserves the key within tx. A concurrent request holding the same
+// key makes the insert wait until that transaction ends; false means the
+// other request committed first and its response should be replayed.
+func (s *Store) Claim(tx sqlx.Execer, userID int, key, endpoint, requestHash string) (bool, error) {
+	_, err := tx.Exec(`
+		DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND created_at <= $3`,
+		userID, key, time.Now().Add(-s.ttl))
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO idempotency_keys (user_id, key, endpoint, request_hash) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO NOTHING`,
+		userID, key, endpoint, requestHash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (s *Store) Save(tx sqlx.Execer, userID int, key string, statusCode int, body []byte) error {
+	_, err := tx.Exec(`
+		UPDATE idempotency_keys SET status_code = $3, response_body = $4
+		WHERE user_id = $1 AND key = $2`,
+		userID, key, statusCode, body)
+	return err
+}
+
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := s.db.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at <= $1", time.Now().Add(-s.ttl))
+			if err != nil && ctx.Err() == nil {
+				log.Printf("[ERR] failed to clean up idempotency keys: %v", err)
+			}
+		}
+	}
+}
+
+// Replay writes the stored response of an already completed request and
+// reports whether the caller is done with c.
+func (s *Store) Replay(c *gin.Context, key, requestHash string) bool {
+	resp, err := s.Lookup(c.GetInt("userID"), key, requestHash)
+	switch {
+	case errors.Is(err, ErrKeyReused):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"errors": "Idempotency-Key was used for a different request"})
+		return true
+	case err != nil:
+		log.Printf("[ERR] failed to look up idempotency key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to look up idempotency key"})
+		return true
+	case resp == nil:
+		return false
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	if len(resp.Body) == 0 {
+		c.Status(*resp.StatusCode)
+	} else {
+		c.Data(*resp.StatusCode, "application/json; charset=utf-8", resp.Body)
+	}
+
+	return true
+}
+
+// Request is one call of an endpoint guarded by a client key. Without a key
+// it guards nothing, and every method lets the call go through.
+type Request struct {
+	store    *Store
+	userID   int
+	key      string
+	endpoint string
+	hash     string
+}
+
+// Begin starts an idempotent call of endpoint by the current user. If the
+// key already completed it replays the stored response, or rejects a key
+// reused for a different request, and reports that the caller is done.
+func (s *Store) Begin(c *gin.Context, key, endpoint string, request interface{}) (*Request, bool) {
+	r := &Request{store: s, userID: c.GetInt("userID"), key: key, endpoint: endpoint, hash: Hash(endpoint, request)}
+	if key != "" && s.Replay(c, key, r.hash) {
+		return nil, true
+	}
+
+	return r, false
+}
+
+// Claim reserves the key within tx; ErrClaimed means another request with
+// the same key committed first.
+func (r *Request) Claim(tx sqlx.Execer) error {
+	if r.key == "" {
+		return nil
+	}
+
+	claimed, err := r.store.Claim(tx, r.userID, r.key, r.endpoint, r.hash)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrClaimed
+	}
+
+	return nil
+}
+
+// Save stores the response within tx, so it commits together with the
+// work it describes.
+func (r *Request) Save(tx sqlx.Execer, statusCode int, body []byte) error {
+	if r.key == "" {
+		return nil
+	}
+
+	return r.store.Save(tx, r.userID, r.key, statusCode, body)
+}
+
+// Finish answers c if err means another request holds the key: its response
+// is replayed once it has completed, otherwise the call is still in
+// progress. It reports whether it answered c.
+func (r *Request) Finish(c *gin.Context, err error) bool {
+	if !errors.Is(err, ErrClaimed) {
+		return false
+	}
+
+	if !r.store.Replay(c, r.key, r.hash) {
+		c.JSON(http.StatusConflict, gin.H{"errors": "Request with this Idempotency-Key is in progress"})
+	}
+
+	return true
+}
@@ -0,0 +1,193 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var keyColumns = []string{"request_hash", "status_code", "response_body"}
+
+func setupStore(t *testing.T) (*Store, *sqlx.DB, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	database := &db.Database{DB: sqlx.NewDb(mockDB, "postgres")}
+	return NewStore(database, time.Hour), database.DB, mock
+}
+
+// serve runs handler as user 1 and returns what it answered.
+func serve(handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		c.Set("userID", 1)
+		handler(c)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	return w
+}
+
+func expectLookup(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT request_hash, status_code, response_body FROM idempotency_keys WHERE user_id = \$1 AND key = \$2 AND created_at > \$3`).
+		WithArgs(1, "key-1", sqlmock.AnyArg()).
+		WillReturnRows(rows)
+}
+
+func TestBegin_ReplaysCompletedRequest(t *testing.T) {
+	store, _, mock := setupStore(t)
+
+	expectLookup(mock, sqlmock.NewRows(keyColumns).AddRow(Hash("buy", "cup"), http.StatusOK, []byte(`{"id":4}`)))
+
+	w := serve(func(c *gin.Context) {
+		_, done := store.Begin(c, "key-1", "buy", "cup")
+		assert.True(t, done)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.JSONEq(t, `{"id": 4}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBegin_ReplaysEmptyBody(t *testing.T) {
+	store, _, mock := setupStore(t)
+
+	expectLookup(mock, sqlmock.NewRows(keyColumns).AddRow(Hash("sendCoin", "bob"), http.StatusOK, nil))
+
+	w := serve(func(c *gin.Context) {
+		_, done := store.Begin(c, "key-1", "sendCoin", "bob")
+		assert.True(t, done)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Empty(t, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBegin_KeyReused(t *testing.T) {
+	store, _, mock := setupStore(t)
+
+	expectLookup(mock, sqlmock.NewRows(keyColumns).AddRow(Hash("buy", "cup"), http.StatusOK, []byte(`{}`)))
+
+	w := serve(func(c *gin.Context) {
+		_, done := store.Begin(c, "key-1", "buy", "pen")
+		assert.True(t, done)
+	})
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBegin_NewKey(t *testing.T) {
+	store, _, mock := setupStore(t)
+
+	expectLookup(mock, sqlmock.NewRows(keyColumns))
+
+	w := serve(func(c *gin.Context) {
+		r, done := store.Begin(c, "key-1", "buy", "cup")
+		require.False(t, done)
+		assert.False(t, r.Finish(c, nil))
+		c.Status(http.StatusCreated)
+	})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequest_WithoutKey(t *testing.T) {
+	store, database, mock := setupStore(t)
+
+	serve(func(c *gin.Context) {
+		r, done := store.Begin(c, "", "buy", "cup")
+		require.False(t, done)
+
+		mock.ExpectBegin()
+		tx, err := database.Beginx()
+		require.NoError(t, err)
+		assert.NoError(t, r.Claim(tx))
+		assert.NoError(t, r.Save(tx, http.StatusOK, nil))
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequest_ClaimAndSave(t *testing.T) {
+	store, database, mock := setupStore(t)
+	hash := Hash("buy", "cup")
+
+	expectLookup(mock, sqlmock.NewRows(keyColumns))
+	mock.ExpectBegin()
+	// A key past the retention window is dropped first, so it can be
+	// claimed again.
+	mock.ExpectExec(`DELETE FROM idempotency_keys WHERE user_id = \$1 AND key = \$2 AND created_at <= \$3`).
+		WithArgs(1, "key-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO idempotency_keys \(user_id, key, endpoint, request_hash\)`).
+		WithArgs(1, "key-1", "buy", hash).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE idempotency_keys SET status_code = \$3, response_body = \$4`).
+		WithArgs(1, "key-1", http.StatusOK, []byte(`{"id":4}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	serve(func(c *gin.Context) {
+		r, done := store.Begin(c, "key-1", "buy", "cup")
+		require.False(t, done)
+
+		tx, err := database.Beginx()
+		require.NoError(t, err)
+		assert.NoError(t, r.Claim(tx))
+		assert.NoError(t, r.Save(tx, http.StatusOK, []byte(`{"id":4}`)))
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequest_InProgress(t *testing.T) {
+	store, database, mock := setupStore(t)
+	hash := Hash("buy", "cup")
+
+	expectLookup(mock, sqlmock.NewRows(keyColumns))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM idempotency_keys`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO idempotency_keys`).
+		WithArgs(1, "key-1", "buy", hash).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// The other request has claimed the key but not saved a response yet.
+	expectLookup(mock, sqlmock.NewRows(keyColumns).AddRow(hash, nil, nil))
+
+	w := serve(func(c *gin.Context) {
+		r, done := store.Begin(c, "key-1", "buy", "cup")
+		require.False(t, done)
+
+		tx, err := database.Beginx()
+		require.NoError(t, err)
+		err = r.Claim(tx)
+		assert.ErrorIs(t, err, ErrClaimed)
+		assert.True(t, r.Finish(c, err))
+	})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Request with this Idempotency-Key is in progress"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckKey(t *testing.T) {
+	assert.NoError(t, CheckKey(""))
+	assert.NoError(t, CheckKey("3f1c9a6e-key"))
+	assert.ErrorIs(t, CheckKey("with space"), ErrInvalidKey)
+	assert.ErrorIs(t, CheckKey(string(make([]byte, maxKeyLength+1))), ErrInvalidKey)
+}
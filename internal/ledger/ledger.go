@@ -0,0 +1,143 @@
+package ledger
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	KindOpening   = "opening"
+	KindGrant     = "grant"
+	KindTransfer  = "transfer"
+	KindPurchase  = "purchase"
+	KindRefund    = "refund"
+	KindDeduction = "deduction"
+	KindAllowance = "allowance"
+)
+
+var ErrUnbalanced = errors.New("ledger entries do not balance")
+
+// Account is a ledger account. User accounts carry the user id, so posting
+// to them also moves the cached users.coins projection.
+type Account struct {
+	Code   string
+	UserID int
+}
+
+var (
+	// IssuanceAccount is where every coin comes from; its balance is minus
+	// the total amount of coins ever granted.
+	IssuanceAccount = Account{Code: "system:issuance"}
+	StoreAccount    = Account{Code: "system:store"}
+)
+
+func UserAccount(userID int) Account {
+	return Account{Code: "user:" + strconv.Itoa(userID), UserID: userID}
+}
+
+// Entry credits Amount to Account; a negative Amount is a debit.
+type Entry struct {
+	Account Account
+	Amount  int
+}
+
+func OpenAccount(tx sqlx.Execer, userID int) error {
+	account := UserAccount(userID)
+	_, err := tx.Exec(`
+		INSERT INTO ledger_accounts (code, user_id) VALUES ($1, $2)
+		ON CONFLICT (code) DO NOTHING`,
+		account.Code, account.UserID)
+	return err
+}
+
+// Post records a balanced transaction and applies it to users.coins. The
+// users_coins_check constraint still rejects overdrafts, and a deferred
+// trigger in the database rejects unbalanced transactions at commit. User
+// accounts are opened on first use, so users created outside the signup
+// flow, by seed scripts for instance, can be posted to as well.
+func Post(tx *sqlx.Tx, kind, reference string, entries ...Entry) (int, error) {
+	sum := 0
+	for _, entry := range entries {
+		if entry.Amount == 0 {
+			return 0, ErrUnbalanced
+		}
+		sum += entry.Amount
+	}
+	if len(entries) < 2 || sum != 0 {
+		return 0, ErrUnbalanced
+	}
+
+	var txnID int
+	err := tx.QueryRowx(`
+		INSERT INTO ledger_txns (kind, reference) VALUES ($1, $2) RETURNING id`,
+		kind, reference).Scan(&txnID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		if entry.Account.UserID != 0 {
+			if err := OpenAccount(tx, entry.Account.UserID); err != nil {
+				return 0, err
+			}
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO ledger_entries (txn_id, account, amount) VALUES ($1, $2, $3)`,
+			txnID, entry.Account.Code, entry.Amount)
+		if err != nil {
+			return 0, err
+		}
+
+		if entry.Account.UserID == 0 {
+			continue
+		}
+
+		_, err = tx.Exec("UPDATE users SET coins = coins + $1 WHERE id = $2", entry.Amount, entry.Account.UserID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return txnID, nil
+}
+
+func Grant(tx *sqlx.Tx, userID, amount int, reference string) (int, error) {
+	return Post(tx, KindGrant, reference,
+		Entry{Account: IssuanceAccount, Amount: -amount},
+		Entry{Account: UserAccount(userID), Amount: amount})
+}
+
+// Allowance credits the monthly allowance for period from the issuance pool.
+func Allowance(tx *sqlx.Tx, userID, amount int, period string) (int, error) {
+	return Post(tx, KindAllowance, "allowance:"+period,
+		Entry{Account: IssuanceAccount, Amount: -amount},
+		Entry{Account: UserAccount(userID), Amount: amount})
+}
+
+// Deduct takes coins back out of circulation, the reverse of Grant.
+func Deduct(tx *sqlx.Tx, userID, amount int, reference string) (int, error) {
+	return Post(tx, KindDeduction, reference,
+		Entry{Account: UserAccount(userID), Amount: -amount},
+		Entry{Account: IssuanceAccount, Amount: amount})
+}
+
+func Transfer(tx *sqlx.Tx, fromUserID, toUserID, amount int) (int, error) {
+	return Post(tx, KindTransfer, "",
+		Entry{Account: UserAccount(fromUserID), Amount: -amount},
+		Entry{Account: UserAccount(toUserID), Amount: amount})
+}
+
+func Purchase(tx *sqlx.Tx, userID, price int, item string) (int, error) {
+	return Post(tx, KindPurchase, item,
+		Entry{Account: UserAccount(userID), Amount: -price},
+		Entry{Account: StoreAccount, Amount: price})
+}
+
+func Refund(tx *sqlx.Tx, userID, amount int, reference string) (int, error) {
+	return Post(tx, KindRefund, reference,
+		Entry{Account: StoreAccount, Amount: -amount},
+		Entry{Account: UserAccount(userID), Amount: amount})
+}
@@ -0,0 +1,71 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPost_Purchase(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO ledger_txns \(kind, reference\) VALUES \(\$1, \$2\) RETURNING id`).
+		WithArgs("purchase", "cup").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs("user:3", 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(5, "user:3", -20).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(-20, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(5, "system:store", 20).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	tx, err := sqlx.NewDb(mockDB, "postgres").Beginx()
+	require.NoError(t, err)
+
+	txnID, err := Purchase(tx, 3, 20, "cup")
+	require.NoError(t, err)
+	assert.Equal(t, 5, txnID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPost_Unbalanced(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectBegin()
+
+	tx, err := sqlx.NewDb(mockDB, "postgres").Beginx()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		entries []Entry
+	}{
+		{name: "No entries"},
+		{name: "Single entry", entries: []Entry{{Account: UserAccount(1), Amount: 10}}},
+		{name: "Sum is not zero", entries: []Entry{{Account: UserAccount(1), Amount: 10}, {Account: IssuanceAccount, Amount: -5}}},
+		{name: "Zero amount", entries: []Entry{{Account: UserAccount(1), Amount: 0}, {Account: IssuanceAccount, Amount: 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Post(tx, KindGrant, "", tt.entries...)
+			assert.ErrorIs(t, err, ErrUnbalanced)
+		})
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
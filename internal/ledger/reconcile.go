@@ -0,0 +1,118 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+)
+
+type Mismatch struct {
+	UserID int    `json:"userId" db:"user_id"`
+	Name   string `json:"name" db:"name"`
+	Cached int    `json:"cached" db:"cached"`
+	Ledger int    `json:"ledger" db:"ledger"`
+}
+
+type Report struct {
+	CheckedAt  time.Time  `json:"checkedAt"`
+	Issued     int        `json:"issued"`
+	StoreTotal int        `json:"storeTotal"`
+	Unbalanced int        `json:"unbalancedTxns"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+func (r *Report) OK() bool {
+	return r.Unbalanced == 0 && len(r.Mismatches) == 0
+}
+
+// Reconcile compares every cached users.coins value with the sum of the
+// user's ledger entries. All queries share one snapshot, so transfers
+// committed while the check runs cannot show up as false mismatches.
+func Reconcile(ctx context.Context, database *db.Database) (*Report, error) {
+	tx, err := database.DB.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Rollback(tx)
+
+	report := Report{CheckedAt: time.Now().UTC(), Mismatches: []Mismatch{}}
+
+	err = tx.Select(&report.Mismatches, `
+		SELECT u.id AS user_id, u.name, COALESCE(u.coins, 0) AS cached, COALESCE(SUM(e.amount), 0) AS ledger
+		FROM users u
+		LEFT JOIN ledger_accounts a ON a.user_id = u.id
+		LEFT JOIN ledger_entries e ON e.account = a.code
+		GROUP BY u.id, u.name, u.coins
+		HAVING COALESCE(u.coins, 0) <> COALESCE(SUM(e.amount), 0)
+		ORDER BY u.id`)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Get(&report.Unbalanced, `
+		SELECT COUNT(*) FROM (
+			SELECT txn_id FROM ledger_entries GROUP BY txn_id HAVING SUM(amount) <> 0
+		) unbalanced`)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowx(`
+		SELECT
+			COALESCE(-SUM(amount) FILTER (WHERE account = $1), 0),
+			COALESCE(SUM(amount) FILTER (WHERE account = $2), 0)
+		FROM ledger_entries`,
+		IssuanceAccount.Code, StoreAccount.Code).Scan(&report.Issued, &report.StoreTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+func Run(ctx context.Context, database *db.Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := Reconcile(ctx, database)
+			switch {
+			case err != nil:
+				if ctx.Err() == nil {
+					log.Printf("[ERR] ledger reconciliation failed: %v", err)
+				}
+			case !report.OK():
+				log.Printf("[ERR] ledger reconciliation: %d mismatched balances, %d unbalanced transactions",
+					len(report.Mismatches), report.Unbalanced)
+			}
+		}
+	}
+}
+
+type LedgerHandler struct {
+	db *db.Database
+}
+
+func NewLedgerHandler(db *db.Database) *LedgerHandler {
+	return &LedgerHandler{db: db}
+}
+
+func (h *LedgerHandler) Reconciliation(c *gin.Context) {
+	report, err := Reconcile(c.Request.Context(), h.db)
+	if err != nil {
+		log.Printf("[ERR] ledger reconciliation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to reconcile ledger"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,37 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT u.id AS user_id, u.name, COALESCE\(u.coins, 0\) AS cached`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "name", "cached", "ledger"}).AddRow(4, "alice", 120, 100))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \( SELECT txn_id FROM ledger_entries`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FILTER \(WHERE account = \$1\)`).
+		WithArgs("system:issuance", "system:store").
+		WillReturnRows(sqlmock.NewRows([]string{"issued", "store"}).AddRow(5000, 300))
+	mock.ExpectRollback()
+
+	report, err := Reconcile(context.Background(), &db.Database{DB: sqlx.NewDb(mockDB, "postgres")})
+	require.NoError(t, err)
+
+	assert.False(t, report.OK())
+	assert.Equal(t, []Mismatch{{UserID: 4, Name: "alice", Cached: 120, Ledger: 100}}, report.Mismatches)
+	assert.Equal(t, 5000, report.Issued)
+	assert.Equal(t, 300, report.StoreTotal)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
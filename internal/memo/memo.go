@@ -0,0 +1,43 @@
+// Package memo checks the free-text notes users attach to coin transfers,
+// gifts and returns.
+package memo
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MaxLength bounds a memo in runes.
+const MaxLength = 280
+
+var tags = map[string]bool{
+	"help":        true,
+	"teamwork":    true,
+	"thanks":      true,
+	"mentoring":   true,
+	"innovation":  true,
+	"celebration": true,
+}
+
+// ValidTag reports whether tag is one of the fixed transfer tags.
+func ValidTag(tag string) bool {
+	return tags[tag]
+}
+
+// Sanitize drops control and invisible formatting characters (bidi
+// overrides, zero-width spaces) so a memo renders the same everywhere, and
+// folds line breaks and runs of whitespace into single spaces.
+func Sanitize(message string) string {
+	message = strings.ToValidUTF8(message, "")
+	message = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, message)
+
+	return strings.Join(strings.Fields(message), " ")
+}
@@ -0,0 +1,28 @@
+package memo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{name: "Plain text", message: "Thanks for the help", expected: "Thanks for the help"},
+		{name: "Whitespace is folded", message: "  Great\tjob\r\non the\n\nrelease  ", expected: "Great job on the release"},
+		{name: "Control characters are dropped", message: "ok\x00\x07!", expected: "ok!"},
+		{name: "Bidi overrides and zero-width spaces are dropped", message: "‮evil​ text⁦", expected: "evil text"},
+		{name: "Invalid UTF-8 is dropped", message: "caf\xc3 ok", expected: "caf ok"},
+		{name: "Unicode text is kept", message: "Спасибо 🙌", expected: "Спасибо 🙌"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Sanitize(tt.message))
+		})
+	}
+}
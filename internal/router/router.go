@@ -1,30 +1,122 @@
 package router
 
 import (
+	"context"
+	"log"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/config"
+	"github.com/jamsi-max/merch-store/internal/allowance"
 	"github.com/jamsi-max/merch-store/internal/auth"
 	"github.com/jamsi-max/merch-store/internal/coin"
 	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jamsi-max/merch-store/internal/ledger"
 	"github.com/jamsi-max/merch-store/internal/store"
 	"github.com/jamsi-max/merch-store/internal/users"
 )
 
-func SetupRouter(db *db.Database, jwtSecret string) *gin.Engine {
+const (
+	revocationSyncInterval = 30 * time.Second
+	idempotencyCleanup     = time.Hour
+	ledgerReconcile        = 10 * time.Minute
+	allowanceCheck         = time.Hour
+)
+
+func SetupRouter(ctx context.Context, db *db.Database, cfg *config.Config) *gin.Engine {
 	r := gin.Default()
 
-	authHandler := auth.NewAuthHandler(db, jwtSecret)
+	keys, err := auth.LoadKeyRing(cfg.JWTKeysDir, cfg.JWTSigningKeyID, cfg.JWTSecret)
+	if err != nil {
+		log.Fatalf("[ERR] failed to load JWT signing keys: %v", err)
+	}
+	r.GET("/.well-known/jwks.json", auth.JWKSHandler(keys))
+
+	revocations := auth.NewRevocationList(db)
+	go revocations.Run(ctx, revocationSyncInterval)
+
+	authHandler := auth.NewAuthHandler(db, cfg, keys, revocations)
 	r.POST("/api/auth", authHandler.Auth)
+	r.POST("/api/auth/refresh", authHandler.Refresh)
+	r.POST("/api/register", authHandler.Register)
 
-	coinHandler := coin.NewCoinHandler(db)
-	storeHandler := store.NewStoreHandler(db)
+	idempotencyStore := idempotency.NewStore(db, cfg.IdempotencyTTL)
+	go idempotencyStore.Run(ctx, idempotencyCleanup)
+
+	coinHandler := coin.NewCoinHandler(db, idempotencyStore)
+	storeHandler := store.NewStoreHandler(db, idempotencyStore)
+	go storeHandler.Catalog.Listen(ctx, db.DSN)
+	r.GET("/api/merch", storeHandler.ListItems)
+	r.GET("/api/merch/:item", storeHandler.GetItem)
 	userHandler := users.NewUserHandler(db)
 
+	ledgerHandler := ledger.NewLedgerHandler(db)
+	go ledger.Run(ctx, db, ledgerReconcile)
+	go allowance.Run(ctx, db, cfg.AllowanceAmount, allowanceCheck)
+
 	protected := r.Group("/api")
-	protected.Use(auth.AuthMiddleware(jwtSecret))
+	protected.Use(auth.AuthMiddleware(keys, revocations))
 
+	protected.POST("/logout", authHandler.Logout)
 	protected.POST("/sendCoin", coinHandler.SendCoin)
-	protected.GET("/buy/:item", storeHandler.BuyItem)
+	protected.POST("/purchases", storeHandler.CreatePurchase)
+	if cfg.LegacyBuyRoute {
+		protected.GET("/buy/:item", deprecated("/api/purchases"), storeHandler.BuyItem)
+	}
+	protected.GET("/orders", storeHandler.ListOrders)
+	protected.POST("/orders/:id/returns", storeHandler.RequestReturn)
+	protected.GET("/cart", storeHandler.GetCart)
+	protected.POST("/cart", storeHandler.AddToCart)
+	protected.DELETE("/cart/:item", storeHandler.RemoveFromCart)
+	protected.POST("/checkout", storeHandler.Checkout)
+	protected.POST("/item-transfers", storeHandler.TransferItems)
 	protected.GET("/info", userHandler.GetUserInfo)
+	protected.GET("/transactions", userHandler.ListTransactions)
+
+	admin := protected.Group("/admin")
+	admin.Use(auth.RequireRole(auth.RoleAdmin))
+
+	admin.PUT("/users/:name/role", userHandler.SetUserRole)
+	admin.PUT("/users/:name/active", userHandler.SetUserActive)
+	admin.POST("/coins/adjustments", coinHandler.AdjustBalances)
+
+	admin.POST("/merch", storeHandler.CreateItem)
+	admin.PATCH("/merch/:item", storeHandler.UpdateItem)
+	admin.DELETE("/merch/:item", storeHandler.RetireItem)
+	admin.POST("/merch/:item/restock", storeHandler.RestockItem)
+	admin.POST("/merch/:item/variants", storeHandler.CreateVariant)
+	admin.PATCH("/merch/:item/variants/:sku", storeHandler.UpdateVariant)
+	admin.POST("/merch/:item/variants/:sku/restock", storeHandler.RestockVariant)
+
+	admin.GET("/price-rules", storeHandler.ListPriceRules)
+	admin.POST("/price-rules", storeHandler.CreatePriceRule)
+	admin.DELETE("/price-rules/:id", storeHandler.DeletePriceRule)
+
+	admin.GET("/promo-codes", storeHandler.ListPromoCodes)
+	admin.POST("/promo-codes", storeHandler.CreatePromoCode)
+	admin.PATCH("/promo-codes/:code", storeHandler.UpdatePromoCode)
+
+	admin.GET("/returns", storeHandler.ListReturns)
+	admin.PATCH("/returns/:id", storeHandler.DecideReturn)
+
+	admin.GET("/ledger/reconciliation", ledgerHandler.Reconciliation)
+
+	desk := protected.Group("/admin/orders")
+	desk.Use(auth.RequireRole(auth.RoleManager, auth.RoleAdmin))
+
+	desk.GET("", storeHandler.ListAllOrders)
+	desk.PATCH("/:id", storeHandler.UpdateOrderStatus)
 
 	return r
 }
+
+// deprecated marks responses of a route that is kept only for old clients
+// and points them to its replacement.
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
@@ -0,0 +1,308 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+)
+
+var itemNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,63}$`)
+
+// stockLimit is a stock field that tells null from a missing field: Set is
+// true for both a number and null, and Value stays nil for null.
+type stockLimit struct {
+	Set   bool
+	Value *int
+}
+
+func (l *stockLimit) UnmarshalJSON(data []byte) error {
+	l.Set = true
+	return json.Unmarshal(data, &l.Value)
+}
+
+func (h *StoreHandler) CreateItem(c *gin.Context) {
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Price       int    `json:"price" binding:"required,min=1"`
+		Description string `json:"description" binding:"max=500"`
+		Category    string `json:"category" binding:"max=64"`
+		Stock       *int   `json:"stock" binding:"omitempty,min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || !itemNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	var item MerchItem
+	err := h.db.DB.Get(&item, `
+		INSERT INTO merch (name, price, description, category, stock) VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+merchColumns,
+		req.Name, req.Price, req.Description, req.Category, req.Stock)
+	if db.IsUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"errors": "Item already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to create merch item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to create item"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.JSON(http.StatusCreated, item)
+}
+
+// UpdateItem edits an item. A number in stock sets the stock limit and null
+// lifts it; leaving stock out keeps the stock as it is.
+func (h *StoreHandler) UpdateItem(c *gin.Context) {
+	var req struct {
+		Price       *int       `json:"price" binding:"omitempty,min=1"`
+		Description *string    `json:"description" binding:"omitempty,max=500"`
+		Category    *string    `json:"category" binding:"omitempty,max=64"`
+		Active      *bool      `json:"active"`
+		Stock       stockLimit `json:"stock"`
+	}
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Stock.Value != nil && *req.Stock.Value < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	// Variants are priced as an offset from the item price, so a lower
+	// price has to leave every variant at a coin or more, sales included.
+	if merch, ok := h.Catalog.Get(c.Param("item")); ok && req.Price != nil {
+		merch.Price = *req.Price
+		if variant, ok := merch.underpricedVariant(time.Now()); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Price is too low for variant " + variant.SKU})
+			return
+		}
+	}
+
+	var item MerchItem
+	err = h.db.DB.Get(&item, `
+		UPDATE merch SET
+			price = COALESCE($1, price),
+			description = COALESCE($2, description),
+			category = COALESCE($3, category),
+			active = COALESCE($4, active),
+			stock = CASE WHEN $5 THEN $6 ELSE stock END,
+			updated_at = now()
+		WHERE name = $7
+		RETURNING `+merchColumns,
+		req.Price, req.Description, req.Category, req.Active, req.Stock.Set, req.Stock.Value, c.Param("item"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Item not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to update merch item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to update item"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.JSON(http.StatusOK, item)
+}
+
+func (h *StoreHandler) RetireItem(c *gin.Context) {
+	res, err := h.db.DB.Exec("UPDATE merch SET active = false, updated_at = now() WHERE name = $1", c.Param("item"))
+	if err != nil {
+		log.Printf("[ERR] failed to retire merch item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to retire item"})
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Item not found"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.Status(http.StatusOK)
+}
+
+// RestockItem adds to the item stock. Items with variants are sold from
+// the variant stock, so those are restocked per variant instead. An item
+// without a stock limit is left unlimited; UpdateItem sets a limit.
+func (h *StoreHandler) RestockItem(c *gin.Context) {
+	var req struct {
+		Quantity int `json:"quantity" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	if merch, ok := h.Catalog.Get(c.Param("item")); ok && merch.HasVariants() {
+		c.JSON(http.StatusConflict, gin.H{"errors": "Item has variants, restock them instead"})
+		return
+	}
+
+	var item MerchItem
+	err := h.db.DB.Get(&item, `
+		UPDATE merch SET stock = stock + $1, updated_at = now()
+		WHERE name = $2 AND stock IS NOT NULL
+		RETURNING `+merchColumns,
+		req.Quantity, c.Param("item"))
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, ok := h.Catalog.Get(c.Param("item")); ok {
+			c.JSON(http.StatusConflict, gin.H{"errors": "Item has no stock limit"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Item not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to restock merch item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to restock item"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.JSON(http.StatusOK, item)
+}
+
+func (h *StoreHandler) CreateVariant(c *gin.Context) {
+	var req struct {
+		SKU        string `json:"sku" binding:"required"`
+		Size       string `json:"size" binding:"max=16"`
+		Color      string `json:"color" binding:"max=32"`
+		PriceDelta int    `json:"priceDelta"`
+		Stock      *int   `json:"stock" binding:"omitempty,min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || !itemNamePattern.MatchString(req.SKU) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	merch, ok := h.Catalog.Get(c.Param("item"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Item not found"})
+		return
+	}
+	if merch.lowestPrice(time.Now())+req.PriceDelta < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Variant price must be positive"})
+		return
+	}
+
+	var variant Variant
+	err := h.db.DB.Get(&variant, `
+		INSERT INTO merch_variants (item, sku, size, color, price_delta, stock) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+variantColumns,
+		merch.Name, req.SKU, req.Size, req.Color, req.PriceDelta, req.Stock)
+	if db.IsUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"errors": "Variant already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to create merch variant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to create variant"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.JSON(http.StatusCreated, variant)
+}
+
+// UpdateVariant edits a variant; setting stock here replaces it, unlike
+// RestockVariant which adds to it. As with UpdateItem, null lifts the stock
+// limit and leaving stock out keeps it.
+func (h *StoreHandler) UpdateVariant(c *gin.Context) {
+	var req struct {
+		PriceDelta *int       `json:"priceDelta"`
+		Stock      stockLimit `json:"stock"`
+		Active     *bool      `json:"active"`
+	}
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Stock.Value != nil && *req.Stock.Value < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	merch, ok := h.Catalog.Get(c.Param("item"))
+	if ok && req.PriceDelta != nil && merch.lowestPrice(time.Now())+*req.PriceDelta < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Variant price must be positive"})
+		return
+	}
+
+	var variant Variant
+	err = h.db.DB.Get(&variant, `
+		UPDATE merch_variants SET
+			price_delta = COALESCE($1, price_delta),
+			stock = CASE WHEN $2 THEN $3 ELSE stock END,
+			active = COALESCE($4, active)
+		WHERE item = $5 AND sku = $6
+		RETURNING `+variantColumns,
+		req.PriceDelta, req.Stock.Set, req.Stock.Value, req.Active, c.Param("item"), c.Param("sku"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Variant not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to update merch variant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to update variant"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.JSON(http.StatusOK, variant)
+}
+
+// RestockVariant adds to the variant stock in place, so purchases that
+// commit meanwhile keep their decrement. Like RestockItem, it leaves a
+// variant without a stock limit unlimited.
+func (h *StoreHandler) RestockVariant(c *gin.Context) {
+	var req struct {
+		Quantity int `json:"quantity" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	var variant Variant
+	err := h.db.DB.Get(&variant, `
+		UPDATE merch_variants SET stock = stock + $1
+		WHERE item = $2 AND sku = $3 AND stock IS NOT NULL
+		RETURNING `+variantColumns,
+		req.Quantity, c.Param("item"), c.Param("sku"))
+	if errors.Is(err, sql.ErrNoRows) {
+		if merch, ok := h.Catalog.Get(c.Param("item")); ok {
+			if _, ok := merch.Variant(c.Param("sku")); ok {
+				c.JSON(http.StatusConflict, gin.H{"errors": "Variant has no stock limit"})
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Variant not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to restock merch variant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to restock variant"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.JSON(http.StatusOK, variant)
+}
+
+// reloadCatalog refreshes this replica right away; if it fails the
+// merch_changed notification will retry the reload shortly.
+func (h *StoreHandler) reloadCatalog() {
+	if err := h.Catalog.Reload(); err != nil {
+		log.Printf("[ERR] failed to reload merch catalog: %v", err)
+	}
+}
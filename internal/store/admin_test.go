@@ -0,0 +1,330 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	catalogColumns        = []string{"name", "price", "description", "category", "active"}
+	catalogVariantColumns = []string{"item", "sku", "size", "color", "price_delta", "stock", "active"}
+	priceRuleRowColumns   = []string{"id", "item", "label", "sale_price", "starts_at", "ends_at"}
+)
+
+// expectCatalogLoad expects a catalog reload; variants default to none.
+func expectCatalogLoad(mock sqlmock.Sqlmock, rows *sqlmock.Rows, variants ...*sqlmock.Rows) {
+	variantRows := sqlmock.NewRows(catalogVariantColumns)
+	if len(variants) > 0 {
+		variantRows = variants[0]
+	}
+	expectCatalogReload(mock, rows, variantRows, sqlmock.NewRows(priceRuleRowColumns))
+}
+
+func expectCatalogReload(mock sqlmock.Sqlmock, rows, variants, rules *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT name, price, description, category, active, stock FROM merch`).WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT item, sku, size, color, price_delta, stock, active FROM merch_variants`).WillReturnRows(variants)
+	mock.ExpectQuery(`SELECT id, item, label, sale_price, starts_at, ends_at FROM price_rules`).WillReturnRows(rules)
+}
+
+func setupAdminServer(t *testing.T) (*gin.Engine, *StoreHandler, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true))
+
+	handler := NewStoreHandler(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")}, nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/api/admin/merch", handler.CreateItem)
+	r.PATCH("/api/admin/merch/:item", handler.UpdateItem)
+	r.DELETE("/api/admin/merch/:item", handler.RetireItem)
+	r.POST("/api/admin/merch/:item/restock", handler.RestockItem)
+	r.POST("/api/admin/merch/:item/variants", handler.CreateVariant)
+	r.PATCH("/api/admin/merch/:item/variants/:sku", handler.UpdateVariant)
+	r.POST("/api/admin/merch/:item/variants/:sku/restock", handler.RestockVariant)
+	r.POST("/api/admin/promo-codes", handler.CreatePromoCode)
+	r.POST("/api/admin/price-rules", handler.CreatePriceRule)
+
+	return r, handler, mock
+}
+
+func serve(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateItem(t *testing.T) {
+	r, handler, mock := setupAdminServer(t)
+
+	mock.ExpectQuery(`INSERT INTO merch \(name, price, description, category, stock\) VALUES \(\$1, \$2, \$3, \$4, \$5\)`).
+		WithArgs("sticker", 5, "Laptop sticker", "stationery", nil).
+		WillReturnRows(sqlmock.NewRows(catalogColumns).AddRow("sticker", 5, "Laptop sticker", "stationery", true))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).
+		AddRow("cup", 20, "", "accessories", true).
+		AddRow("sticker", 5, "Laptop sticker", "stationery", true))
+
+	w := serve(r, http.MethodPost, "/api/admin/merch",
+		`{"name": "sticker", "price": 5, "description": "Laptop sticker", "category": "stationery"}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	item, ok := handler.Catalog.Get("sticker")
+	assert.True(t, ok)
+	assert.Equal(t, 5, item.Price)
+}
+
+func TestCreateItem_Duplicate(t *testing.T) {
+	r, _, mock := setupAdminServer(t)
+
+	mock.ExpectQuery(`INSERT INTO merch`).
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	w := serve(r, http.MethodPost, "/api/admin/merch", `{"name": "cup", "price": 20}`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateItem_InvalidName(t *testing.T) {
+	r, _, mock := setupAdminServer(t)
+
+	w := serve(r, http.MethodPost, "/api/admin/merch", `{"name": "Big Cup!", "price": 20}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateItem_Reprice(t *testing.T) {
+	r, handler, mock := setupAdminServer(t)
+
+	mock.ExpectQuery(`UPDATE merch SET`).
+		WithArgs(25, nil, nil, nil, false, nil, "cup").
+		WillReturnRows(sqlmock.NewRows(catalogColumns).AddRow("cup", 25, "", "accessories", true))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 25, "", "accessories", true))
+
+	w := serve(r, http.MethodPatch, "/api/admin/merch/cup", `{"price": 25}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	item, _ := handler.Catalog.Get("cup")
+	assert.Equal(t, 25, item.Price)
+}
+
+func TestRetireItem(t *testing.T) {
+	r, handler, mock := setupAdminServer(t)
+
+	mock.ExpectExec(`UPDATE merch SET active = false, updated_at = now\(\) WHERE name = \$1`).
+		WithArgs("cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", false))
+
+	w := serve(r, http.MethodDelete, "/api/admin/merch/cup", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	item, _ := handler.Catalog.Get("cup")
+	assert.False(t, item.Active)
+
+	mock.ExpectExec(`UPDATE merch SET active = false`).
+		WithArgs("ghost").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w = serve(r, http.MethodDelete, "/api/admin/merch/ghost", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateItem_Stock(t *testing.T) {
+	r, handler, mock := setupAdminServer(t)
+	stockColumns := append(catalogColumns, "stock")
+
+	mock.ExpectQuery(`UPDATE merch SET`).
+		WithArgs(nil, nil, nil, nil, true, 5, "cup").
+		WillReturnRows(sqlmock.NewRows(stockColumns).AddRow("cup", 20, "", "accessories", true, 5))
+	expectCatalogLoad(mock, sqlmock.NewRows(stockColumns).AddRow("cup", 20, "", "accessories", true, 5))
+
+	w := serve(r, http.MethodPatch, "/api/admin/merch/cup", `{"stock": 5}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	item, _ := handler.Catalog.Get("cup")
+	require.NotNil(t, item.Stock)
+	assert.Equal(t, 5, *item.Stock)
+
+	mock.ExpectQuery(`UPDATE merch SET`).
+		WithArgs(nil, nil, nil, nil, true, nil, "cup").
+		WillReturnRows(sqlmock.NewRows(stockColumns).AddRow("cup", 20, "", "accessories", true, nil))
+	expectCatalogLoad(mock, sqlmock.NewRows(stockColumns).AddRow("cup", 20, "", "accessories", true, nil))
+
+	w = serve(r, http.MethodPatch, "/api/admin/merch/cup", `{"stock": null}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	item, _ = handler.Catalog.Get("cup")
+	assert.Nil(t, item.Stock)
+
+	w = serve(r, http.MethodPatch, "/api/admin/merch/cup", `{"stock": -1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRestockItem(t *testing.T) {
+	r, handler, mock := setupAdminServer(t)
+	stockColumns := append(catalogColumns, "stock")
+
+	mock.ExpectQuery(`UPDATE merch SET stock = stock \+ \$1`).
+		WithArgs(10, "cup").
+		WillReturnRows(sqlmock.NewRows(stockColumns).AddRow("cup", 20, "", "accessories", true, 12))
+	expectCatalogLoad(mock, sqlmock.NewRows(stockColumns).AddRow("cup", 20, "", "accessories", true, 12))
+
+	w := serve(r, http.MethodPost, "/api/admin/merch/cup/restock", `{"quantity": 10}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	item, _ := handler.Catalog.Get("cup")
+	require.NotNil(t, item.Stock)
+	assert.Equal(t, 12, *item.Stock)
+
+	w = serve(r, http.MethodPost, "/api/admin/merch/cup/restock", `{"quantity": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRestockItem_Unlimited(t *testing.T) {
+	r, handler, mock := setupAdminServer(t)
+
+	mock.ExpectQuery(`UPDATE merch SET stock = stock \+ \$1`).
+		WithArgs(10, "cup").
+		WillReturnError(sql.ErrNoRows)
+
+	w := serve(r, http.MethodPost, "/api/admin/merch/cup/restock", `{"quantity": 10}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Item has no stock limit"}`, w.Body.String())
+
+	item, _ := handler.Catalog.Get("cup")
+	assert.Nil(t, item.Stock)
+
+	mock.ExpectQuery(`UPDATE merch SET stock = stock \+ \$1`).
+		WithArgs(10, "lamp").
+		WillReturnError(sql.ErrNoRows)
+
+	w = serve(r, http.MethodPost, "/api/admin/merch/lamp/restock", `{"quantity": 10}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateVariant(t *testing.T) {
+	r, handler, mock := setupAdminServer(t)
+
+	mock.ExpectQuery(`INSERT INTO merch_variants \(item, sku, size, color, price_delta, stock\)`).
+		WithArgs("cup", "cup-large", "l", "", 5, 3).
+		WillReturnRows(sqlmock.NewRows(catalogVariantColumns).AddRow("cup", "cup-large", "l", "", 5, 3, true))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true),
+		sqlmock.NewRows(catalogVariantColumns).AddRow("cup", "cup-large", "l", "", 5, 3, true))
+
+	w := serve(r, http.MethodPost, "/api/admin/merch/cup/variants", `{"sku": "cup-large", "size": "l", "priceDelta": 5, "stock": 3}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	item, _ := handler.Catalog.Get("cup")
+	assert.True(t, item.HasVariants())
+
+	w = serve(r, http.MethodPost, "/api/admin/merch/cup/variants", `{"sku": "cup-free", "priceDelta": -20}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(r, http.MethodPost, "/api/admin/merch/lamp/variants", `{"sku": "lamp-red"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateVariant(t *testing.T) {
+	r, _, mock := setupAdminServer(t)
+
+	mock.ExpectQuery(`UPDATE merch_variants SET`).
+		WithArgs(nil, true, 0, nil, "cup", "cup-large").
+		WillReturnRows(sqlmock.NewRows(catalogVariantColumns).AddRow("cup", "cup-large", "l", "", 5, 0, true))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true))
+
+	w := serve(r, http.MethodPatch, "/api/admin/merch/cup/variants/cup-large", `{"stock": 0}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(r, http.MethodPatch, "/api/admin/merch/cup/variants/cup-large", `{"stock": -1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mock.ExpectQuery(`UPDATE merch_variants SET`).
+		WillReturnError(sql.ErrNoRows)
+
+	w = serve(r, http.MethodPatch, "/api/admin/merch/cup/variants/cup-tiny", `{"active": false}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateVariant_Stock(t *testing.T) {
+	r, _, mock := setupAdminServer(t)
+
+	// null lifts the stock limit.
+	mock.ExpectQuery(`UPDATE merch_variants SET`).
+		WithArgs(nil, true, nil, nil, "cup", "cup-large").
+		WillReturnRows(sqlmock.NewRows(catalogVariantColumns).AddRow("cup", "cup-large", "l", "", 5, nil, true))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true))
+
+	w := serve(r, http.MethodPatch, "/api/admin/merch/cup/variants/cup-large", `{"stock": null}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"sku": "cup-large", "size": "l", "color": "", "priceDelta": 5, "stock": null, "active": true}`, w.Body.String())
+
+	// Leaving stock out keeps it as it is.
+	mock.ExpectQuery(`UPDATE merch_variants SET`).
+		WithArgs(nil, false, nil, false, "cup", "cup-large").
+		WillReturnRows(sqlmock.NewRows(catalogVariantColumns).AddRow("cup", "cup-large", "l", "", 5, 3, false))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true))
+
+	w = serve(r, http.MethodPatch, "/api/admin/merch/cup/variants/cup-large", `{"active": false}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRestockVariant(t *testing.T) {
+	r, handler, mock := setupAdminServer(t)
+
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true),
+		sqlmock.NewRows(catalogVariantColumns).AddRow("cup", "cup-large", "l", "", 5, 2, true))
+	require.NoError(t, handler.Catalog.Reload())
+
+	w := serve(r, http.MethodPost, "/api/admin/merch/cup/restock", `{"quantity": 10}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	mock.ExpectQuery(`UPDATE merch_variants SET stock = stock \+ \$1`).
+		WithArgs(10, "cup", "cup-large").
+		WillReturnRows(sqlmock.NewRows(catalogVariantColumns).AddRow("cup", "cup-large", "l", "", 5, 12, true))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true),
+		sqlmock.NewRows(catalogVariantColumns).AddRow("cup", "cup-large", "l", "", 5, 12, true))
+
+	w = serve(r, http.MethodPost, "/api/admin/merch/cup/variants/cup-large/restock", `{"quantity": 10}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"sku": "cup-large", "size": "l", "color": "", "priceDelta": 5, "stock": 12, "active": true}`, w.Body.String())
+
+	mock.ExpectQuery(`UPDATE merch_variants SET stock`).
+		WithArgs(10, "cup", "cup-tiny").
+		WillReturnError(sql.ErrNoRows)
+
+	w = serve(r, http.MethodPost, "/api/admin/merch/cup/variants/cup-tiny/restock", `{"quantity": 10}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,262 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	checkoutEndpoint = "checkout"
+	cartColumns      = "item, variant, quantity, unit_price"
+)
+
+var (
+	errCartEmpty     = errors.New("cart is empty")
+	errPricesChanged = errors.New("prices changed")
+)
+
+// CartLine is an item, or one variant of it, in a cart. UnitPrice is the
+// price quoted when the line was last added.
+type CartLine struct {
+	Item      string `json:"item" db:"item"`
+	Variant   string `json:"variant,omitempty" db:"variant"`
+	Quantity  int    `json:"quantity" db:"quantity"`
+	UnitPrice int    `json:"unitPrice" db:"unit_price"`
+}
+
+// reference names the line in ledger transactions and idempotency hashes.
+func (l CartLine) reference() string {
+	if l.Variant == "" {
+		return l.Item
+	}
+	return l.Item + "/" + l.Variant
+}
+
+type Cart struct {
+	Items []CartLine `json:"items"`
+	Total int        `json:"total"`
+}
+
+func newCart(lines []CartLine) Cart {
+	cart := Cart{Items: lines}
+	for _, line := range lines {
+		cart.Total += line.UnitPrice * line.Quantity
+	}
+	return cart
+}
+
+func (h *StoreHandler) GetCart(c *gin.Context) {
+	lines := []CartLine{}
+	err := h.db.DB.Select(&lines, `
+		SELECT `+cartColumns+` FROM cart_items WHERE user_id = $1 ORDER BY item, variant`,
+		c.GetInt("userID"))
+	if err != nil {
+		log.Printf("[ERR] failed to get cart: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newCart(lines))
+}
+
+// AddToCart adds to the quantity already in the cart and re-quotes the whole
+// line at the current price.
+func (h *StoreHandler) AddToCart(c *gin.Context) {
+	var req struct {
+		Item     string `json:"item" binding:"required"`
+		Variant  string `json:"variant"`
+		Quantity int    `json:"quantity" binding:"required,min=1,max=99"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	price, err := h.quote(req.Item, req.Variant)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": quoteMessages[err]})
+		return
+	}
+
+	var line CartLine
+	err = h.db.DB.Get(&line, `
+		INSERT INTO cart_items (user_id, item, variant, quantity, unit_price) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, item, variant) DO UPDATE SET
+			quantity = cart_items.quantity + EXCLUDED.quantity,
+			unit_price = EXCLUDED.unit_price,
+			updated_at = now()
+		RETURNING `+cartColumns,
+		c.GetInt("userID"), req.Item, req.Variant, req.Quantity, price)
+	if db.IsCheckViolation(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "At most 99 of an item fit in the cart"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to add to cart: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to add to cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, line)
+}
+
+func (h *StoreHandler) RemoveFromCart(c *gin.Context) {
+	res, err := h.db.DB.Exec(`
+		DELETE FROM cart_items WHERE user_id = $1 AND item = $2 AND variant = $3`,
+		c.GetInt("userID"), c.Param("item"), c.Query("variant"))
+	if err != nil {
+		log.Printf("[ERR] failed to remove from cart: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to remove from cart"})
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Item not in cart"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// Checkout buys everything in the cart in one transaction: either every
+// line becomes an order or nothing is charged. Lines are processed in key
+// order, so concurrent checkouts lock stock rows in the same order.
+func (h *StoreHandler) Checkout(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	idempotencyKey, err := idempotency.KeyFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid Idempotency-Key"})
+		return
+	}
+
+	// The body is optional and only carries a promo code.
+	var req struct {
+		PromoCode string `json:"promoCode,omitempty"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+	req.PromoCode = normalizePromoCode(req.PromoCode)
+
+	idempotent, done := h.idempotency.Begin(c, idempotencyKey, checkoutEndpoint, req)
+	if done {
+		return
+	}
+
+	var (
+		lines  []CartLine
+		failed CartLine
+		body   []byte
+	)
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		if err := idempotent.Claim(tx); err != nil {
+			return err
+		}
+
+		lines = lines[:0]
+		err := tx.Select(&lines, `
+			SELECT `+cartColumns+` FROM cart_items WHERE user_id = $1 ORDER BY item, variant FOR UPDATE`,
+			userID)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return errCartEmpty
+		}
+
+		changed := false
+		for i, line := range lines {
+			price, err := h.quote(line.Item, line.Variant)
+			if err != nil {
+				failed = line
+				return err
+			}
+			if price != line.UnitPrice {
+				lines[i].UnitPrice = price
+				changed = true
+			}
+		}
+		if changed {
+			return errPricesChanged
+		}
+
+		promo, total, err := applyPromo(tx, userID, req.PromoCode, lines)
+		if err != nil {
+			return err
+		}
+
+		if _, err := lockBalance(tx, userID, total); err != nil {
+			return err
+		}
+
+		orders := make([]Order, 0, len(lines))
+		for _, line := range lines {
+			order, err := placeOrder(tx, userID, line, promo, nil)
+			if err != nil {
+				failed = line
+				return err
+			}
+			orders = append(orders, order)
+		}
+
+		if _, err := tx.Exec("DELETE FROM cart_items WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+
+		if body, err = json.Marshal(gin.H{"orders": orders, "total": total}); err != nil {
+			return err
+		}
+
+		return idempotent.Save(tx, http.StatusOK, body)
+	})
+
+	switch {
+	case err == nil:
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	case errors.Is(err, errCartEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Cart is empty"})
+	case errors.Is(err, errPricesChanged):
+		h.requote(userID, lines)
+		c.JSON(http.StatusConflict, gin.H{"errors": "Prices changed", "cart": newCart(lines)})
+	case errors.Is(err, errItemNotFound), errors.Is(err, errVariantRequired), errors.Is(err, errVariantNotFound):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Item is no longer available: " + failed.reference()})
+	case errors.Is(err, errPriceTooLow):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Item price is below 1 coin: " + failed.reference()})
+	case errors.Is(err, errInsufficientFunds), db.IsCheckViolation(err):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Insufficient funds"})
+	case errors.Is(err, errOutOfStock):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Out of stock: " + failed.reference()})
+	case promoMessages[err] != "":
+		c.JSON(http.StatusBadRequest, gin.H{"errors": promoMessages[err]})
+	case idempotent.Finish(c, err):
+	default:
+		log.Printf("[ERR] checkout failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Checkout failed"})
+	}
+}
+
+// requote stores the new prices after a checkout was refused, so the next
+// checkout goes through at the prices the buyer has now been shown.
+func (h *StoreHandler) requote(userID int, lines []CartLine) {
+	for _, line := range lines {
+		_, err := h.db.DB.Exec(`
+			UPDATE cart_items SET unit_price = $1, updated_at = now()
+			WHERE user_id = $2 AND item = $3 AND variant = $4`,
+			line.UnitPrice, userID, line.Item, line.Variant)
+		if err != nil {
+			log.Printf("[ERR] failed to re-quote cart: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var cartRowColumns = []string{"item", "variant", "quantity", "unit_price"}
+
+func expectCartLock(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT item, variant, quantity, unit_price FROM cart_items WHERE user_id = \$1 ORDER BY item, variant FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(rows)
+}
+
+func expectLinePurchase(mock sqlmock.Sqlmock, txnID int, reference string, amount int) {
+	mock.ExpectQuery(`INSERT INTO ledger_txns`).
+		WithArgs("purchase", reference).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(txnID))
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs("user:1", 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(txnID, "user:1", -amount).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(-amount, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(txnID, "system:store", amount).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestAddToCart(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	mock.ExpectQuery(`INSERT INTO cart_items \(user_id, item, variant, quantity, unit_price\)`).
+		WithArgs(1, "t-shirt", "t-shirt-xl", 2, 90).
+		WillReturnRows(sqlmock.NewRows(cartRowColumns).AddRow("t-shirt", "t-shirt-xl", 3, 90))
+
+	w := serve(r, http.MethodPost, "/api/cart", `{"item": "t-shirt", "variant": "t-shirt-xl", "quantity": 2}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"item": "t-shirt", "variant": "t-shirt-xl", "quantity": 3, "unitPrice": 90}`, w.Body.String())
+
+	mock.ExpectQuery(`INSERT INTO cart_items`).
+		WithArgs(1, "cup", "", 90, 20).
+		WillReturnError(&pq.Error{Code: "23514"})
+
+	w = serve(r, http.MethodPost, "/api/cart", `{"item": "cup", "quantity": 90}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	tests := []struct {
+		name         string
+		body         string
+		expectedBody string
+	}{
+		{name: "Retired item", body: `{"item": "umbrella", "quantity": 1}`, expectedBody: `{"errors": "Item not found"}`},
+		{name: "Variant missing", body: `{"item": "t-shirt", "quantity": 1}`, expectedBody: `{"errors": "Variant required"}`},
+		{name: "Zero quantity", body: `{"item": "cup", "quantity": 0}`, expectedBody: `{"errors": "Invalid request"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/cart", tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestGetAndRemoveCart(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	mock.ExpectQuery(`SELECT item, variant, quantity, unit_price FROM cart_items WHERE user_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(cartRowColumns).
+			AddRow("cup", "", 2, 20).
+			AddRow("t-shirt", "t-shirt-m", 1, 80))
+
+	w := serve(r, http.MethodGet, "/api/cart", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var cart Cart
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &cart))
+	assert.Len(t, cart.Items, 2)
+	assert.Equal(t, 120, cart.Total)
+
+	mock.ExpectExec(`DELETE FROM cart_items WHERE user_id = \$1 AND item = \$2 AND variant = \$3`).
+		WithArgs(1, "t-shirt", "t-shirt-m").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM cart_items`).
+		WithArgs(1, "cup", "").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w = serve(r, http.MethodDelete, "/api/cart/t-shirt?variant=t-shirt-m", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(r, http.MethodDelete, "/api/cart/cup", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckout(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now()
+
+	expectCartLock(mock, sqlmock.NewRows(cartRowColumns).
+		AddRow("cup", "", 2, 20).
+		AddRow("t-shirt", "t-shirt-m", 1, 80))
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id=\$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(120))
+
+	mock.ExpectQuery(`SELECT stock FROM merch WHERE name = \$1 FOR UPDATE`).
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(nil))
+	expectLinePurchase(mock, 9, "cup", 40)
+	mock.ExpectQuery(`INSERT INTO orders`).
+		WithArgs(1, "cup", "", 20, 20, "", 2, 9, nil, "").
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).AddRow(5, "cup", 20, 2, "placed", now, now))
+
+	mock.ExpectQuery(`SELECT stock FROM merch_variants WHERE sku = \$1 FOR UPDATE`).
+		WithArgs("t-shirt-m").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(5))
+	mock.ExpectExec(`UPDATE merch_variants SET stock = stock - \$1 WHERE sku = \$2`).
+		WithArgs(1, "t-shirt-m").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLinePurchase(mock, 10, "t-shirt/t-shirt-m", 80)
+	mock.ExpectQuery(`INSERT INTO orders`).
+		WithArgs(1, "t-shirt", "t-shirt-m", 80, 80, "", 1, 10, nil, "").
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "variant")).AddRow(6, "t-shirt", 80, 1, "placed", now, now, "t-shirt-m"))
+
+	mock.ExpectExec(`DELETE FROM cart_items WHERE user_id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodPost, "/api/checkout", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var res struct {
+		Orders []Order `json:"orders"`
+		Total  int     `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Len(t, res.Orders, 2)
+	assert.Equal(t, 120, res.Total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckout_Rejected(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	expectCartLock(mock, sqlmock.NewRows(cartRowColumns))
+	mock.ExpectRollback()
+
+	w := serve(r, http.MethodPost, "/api/checkout", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Cart is empty"}`, w.Body.String())
+
+	// An empty chunked body has no Content-Length but is still no body.
+	expectCartLock(mock, sqlmock.NewRows(cartRowColumns))
+	mock.ExpectRollback()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/checkout", strings.NewReader(""))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.JSONEq(t, `{"errors": "Cart is empty"}`, w.Body.String())
+
+	w = serve(r, http.MethodPost, "/api/checkout", `{"promoCode": `)
+	assert.JSONEq(t, `{"errors": "Invalid request"}`, w.Body.String())
+
+	expectCartLock(mock, sqlmock.NewRows(cartRowColumns).
+		AddRow("cup", "", 1, 20).
+		AddRow("umbrella", "", 1, 200))
+	mock.ExpectRollback()
+
+	w = serve(r, http.MethodPost, "/api/checkout", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Item is no longer available: umbrella"}`, w.Body.String())
+
+	expectCartLock(mock, sqlmock.NewRows(cartRowColumns).
+		AddRow("cup", "", 1, 20).
+		AddRow("t-shirt", "t-shirt-m", 1, 80))
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id=\$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(500))
+	mock.ExpectQuery(`SELECT stock FROM merch WHERE name = \$1 FOR UPDATE`).
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(nil))
+	expectLinePurchase(mock, 9, "cup", 20)
+	mock.ExpectQuery(`INSERT INTO orders`).
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).AddRow(5, "cup", 20, 1, "placed", time.Now(), time.Now()))
+	mock.ExpectQuery(`SELECT stock FROM merch_variants WHERE sku = \$1 FOR UPDATE`).
+		WithArgs("t-shirt-m").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(0))
+	mock.ExpectRollback()
+
+	w = serve(r, http.MethodPost, "/api/checkout", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Out of stock: t-shirt/t-shirt-m"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckout_PricesChanged(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	expectCartLock(mock, sqlmock.NewRows(cartRowColumns).
+		AddRow("cup", "", 2, 15).
+		AddRow("t-shirt", "t-shirt-m", 1, 80))
+	mock.ExpectRollback()
+	mock.ExpectExec(`UPDATE cart_items SET unit_price = \$1`).
+		WithArgs(20, 1, "cup", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE cart_items SET unit_price = \$1`).
+		WithArgs(80, 1, "t-shirt", "t-shirt-m").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := serve(r, http.MethodPost, "/api/checkout", "")
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	var res struct {
+		Errors string `json:"errors"`
+		Cart   Cart   `json:"cart"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "Prices changed", res.Errors)
+	assert.Equal(t, 120, res.Cart.Total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/lib/pq"
+)
+
+const (
+	catalogChannel       = "merch_changed"
+	catalogReloadDelay   = 2 * time.Second
+	listenerPingInterval = 90 * time.Second
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = time.Minute
+)
+
+const (
+	merchColumns     = "name, price, description, category, active, stock"
+	variantColumns   = "item, sku, size, color, price_delta, stock, active"
+	priceRuleColumns = "id, item, label, sale_price, starts_at, ends_at"
+)
+
+// MerchItem.Stock is nil for items sold without a limit.
+type MerchItem struct {
+	Name        string      `json:"name" db:"name"`
+	Price       int         `json:"price" db:"price"`
+	Description string      `json:"description" db:"description"`
+	Category    string      `json:"category" db:"category"`
+	Active      bool        `json:"active" db:"active"`
+	Stock       *int        `json:"stock" db:"stock"`
+	Variants    []Variant   `json:"variants,omitempty" db:"-"`
+	PriceRules  []PriceRule `json:"priceRules,omitempty" db:"-"`
+}
+
+// PriceRule replaces the item price between StartsAt and EndsAt; a rule
+// without EndsAt is a lasting price change. When rules overlap the one
+// that started last wins, so a sale can run on top of a price change.
+type PriceRule struct {
+	ID        int        `json:"id" db:"id"`
+	Item      string     `json:"item" db:"item"`
+	Label     string     `json:"label" db:"label"`
+	SalePrice int        `json:"salePrice" db:"sale_price"`
+	StartsAt  time.Time  `json:"startsAt" db:"starts_at"`
+	EndsAt    *time.Time `json:"endsAt" db:"ends_at"`
+}
+
+func (r PriceRule) activeAt(t time.Time) bool {
+	return !t.Before(r.StartsAt) && (r.EndsAt == nil || t.Before(*r.EndsAt))
+}
+
+// PriceRule returns the rule in effect at t. Rules are kept ordered by
+// start, so the last match is the one that started last.
+func (m MerchItem) PriceRule(t time.Time) (PriceRule, bool) {
+	for i := len(m.PriceRules) - 1; i >= 0; i-- {
+		if m.PriceRules[i].activeAt(t) {
+			return m.PriceRules[i], true
+		}
+	}
+	return PriceRule{}, false
+}
+
+// PriceAt is the unit price of the item at t, before any variant delta.
+// Rules are evaluated on every call, so a sale starts and ends on time
+// without waiting for a catalog reload.
+func (m MerchItem) PriceAt(t time.Time) int {
+	if rule, ok := m.PriceRule(t); ok {
+		return rule.SalePrice
+	}
+	return m.Price
+}
+
+// lowestPrice is the lowest price the item has at t or is scheduled to have
+// later, before any variant delta.
+func (m MerchItem) lowestPrice(t time.Time) int {
+	lowest := m.Price
+	for _, rule := range m.PriceRules {
+		if (rule.EndsAt == nil || rule.EndsAt.After(t)) && rule.SalePrice < lowest {
+			lowest = rule.SalePrice
+		}
+	}
+	return lowest
+}
+
+// underpricedVariant returns a variant that would sell for less than a coin
+// at the item price or at a sale price that is on or still to come.
+func (m MerchItem) underpricedVariant(t time.Time) (Variant, bool) {
+	lowest := m.lowestPrice(t)
+	for _, variant := range m.Variants {
+		if lowest+variant.PriceDelta < 1 {
+			return variant, true
+		}
+	}
+	return Variant{}, false
+}
+
+// Variant is a size/colour SKU of an item with its own stock; its price is
+// the item price plus PriceDelta.
+type Variant struct {
+	Item       string `json:"-" db:"item"`
+	SKU        string `json:"sku" db:"sku"`
+	Size       string `json:"size" db:"size"`
+	Color      string `json:"color" db:"color"`
+	PriceDelta int    `json:"priceDelta" db:"price_delta"`
+	Stock      *int   `json:"stock" db:"stock"`
+	Active     bool   `json:"active" db:"active"`
+}
+
+func (v Variant) InStock() bool {
+	return v.Stock == nil || *v.Stock > 0
+}
+
+// InStock reports whether the item can be bought at all: items with
+// variants are in stock while any active variant is.
+func (m MerchItem) InStock() bool {
+	if len(m.Variants) == 0 {
+		return m.Stock == nil || *m.Stock > 0
+	}
+
+	for _, variant := range m.Variants {
+		if variant.Active && variant.InStock() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasVariants reports whether buying the item requires choosing a variant.
+func (m MerchItem) HasVariants() bool {
+	for _, variant := range m.Variants {
+		if variant.Active {
+			return true
+		}
+	}
+	return false
+}
+
+func (m MerchItem) Variant(sku string) (Variant, bool) {
+	for _, variant := range m.Variants {
+		if variant.SKU == sku {
+			return variant, true
+		}
+	}
+	return Variant{}, false
+}
+
+// Catalog is a copy-on-write snapshot of the merch table: readers never
+// lock, Reload swaps in a freshly loaded map.
+type Catalog struct {
+	db    *db.Database
+	items atomic.Pointer[map[string]MerchItem]
+}
+
+func NewCatalog(db *db.Database) *Catalog {
+	catalog := &Catalog{db: db}
+	catalog.items.Store(&map[string]MerchItem{})
+	return catalog
+}
+
+func (c *Catalog) Reload() error {
+	var rows []MerchItem
+	err := c.db.DB.Select(&rows, "SELECT "+merchColumns+" FROM merch")
+	if err != nil {
+		return err
+	}
+
+	var variants []Variant
+	err = c.db.DB.Select(&variants, "SELECT "+variantColumns+" FROM merch_variants ORDER BY item, sku")
+	if err != nil {
+		return err
+	}
+
+	// Rules that are over are dropped here; ones that end after this reload
+	// are filtered by time on use.
+	var rules []PriceRule
+	err = c.db.DB.Select(&rules, `
+		SELECT `+priceRuleColumns+` FROM price_rules
+		WHERE ends_at IS NULL OR ends_at > now()
+		ORDER BY item, starts_at, id`)
+	if err != nil {
+		return err
+	}
+
+	items := make(map[string]MerchItem, len(rows))
+	for _, item := range rows {
+		items[item.Name] = item
+	}
+
+	for _, variant := range variants {
+		if item, ok := items[variant.Item]; ok {
+			item.Variants = append(item.Variants, variant)
+			items[variant.Item] = item
+		}
+	}
+
+	for _, rule := range rules {
+		if item, ok := items[rule.Item]; ok {
+			item.PriceRules = append(item.PriceRules, rule)
+			items[rule.Item] = item
+		}
+	}
+
+	c.items.Store(&items)
+	return nil
+}
+
+func (c *Catalog) Get(name string) (MerchItem, bool) {
+	item, ok := (*c.items.Load())[name]
+	return item, ok
+}
+
+func (c *Catalog) Items() []MerchItem {
+	snapshot := *c.items.Load()
+
+	items := make([]MerchItem, 0, len(snapshot))
+	for _, item := range snapshot {
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+// Listen reloads the catalog whenever another replica (or anyone else)
+// changes the merch table; the notification comes from a trigger. Every sale
+// of a limited item changes its stock and notifies, so reloads are spaced at
+// least catalogReloadDelay apart and the notifications in between share one.
+func (c *Catalog) Listen(ctx context.Context, dsn string) {
+	if dsn == "" {
+		return
+	}
+
+	listener := pq.NewListener(dsn, listenerMinReconnect, listenerMaxReconnect, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[ERR] merch catalog listener: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(catalogChannel); err != nil {
+		log.Printf("[ERR] failed to listen for merch changes: %v", err)
+		return
+	}
+
+	var (
+		reload   <-chan time.Time
+		reloaded time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+			// A nil notification means the connection was re-established and
+			// changes may have been missed, so reload in both cases.
+			if reload == nil {
+				reload = time.After(time.Until(reloaded.Add(catalogReloadDelay)))
+			}
+		case <-reload:
+			reload, reloaded = nil, time.Now()
+			if err := c.Reload(); err != nil {
+				log.Printf("[ERR] failed to reload merch catalog: %v", err)
+			}
+		case <-time.After(listenerPingInterval):
+			if err := listener.Ping(); err != nil {
+				log.Printf("[ERR] merch catalog listener ping failed: %v", err)
+			}
+		}
+	}
+}
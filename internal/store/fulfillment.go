@@ -0,0 +1,204 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/ledger"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	errOrderNotFound      = errors.New("order not found")
+	errInvalidTransition  = errors.New("invalid order status transition")
+	errOrderNotRefundable = errors.New("order was not paid through the ledger")
+)
+
+// paidOrder is an order with its buyer and the ledger transaction that paid
+// for it. Orders backfilled from before the ledger have no such transaction.
+type paidOrder struct {
+	Order
+	UserID      int  `db:"user_id"`
+	LedgerTxnID *int `db:"ledger_txn_id"`
+}
+
+const paidOrderColumns = orderColumns + ", user_id, ledger_txn_id"
+
+// orderTransitions lists where an order may go next. Delivered and
+// cancelled orders are final.
+var orderTransitions = map[string][]string{
+	OrderPlaced:         {OrderApproved, OrderCancelled},
+	OrderApproved:       {OrderReadyForPickup, OrderCancelled},
+	OrderReadyForPickup: {OrderDelivered, OrderCancelled},
+}
+
+func canTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+type DeskOrder struct {
+	Order
+	User string `json:"user" db:"user_name"`
+}
+
+func (h *StoreHandler) ListAllOrders(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && !orderStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid status"})
+		return
+	}
+
+	pageOrders[DeskOrder](c, h.db, `
+		SELECT o.id, o.item, o.variant, o.unit_price, o.list_price, o.promo_code, o.quantity, o.status, o.created_at, o.updated_at, o.recipient_id, o.gift_note, u.name AS user_name
+		FROM orders o
+		JOIN users u ON u.id = o.user_id
+		WHERE ($1 = '' OR o.status = $1) AND ($2 = 0 OR o.id < $2)
+		ORDER BY o.id DESC
+		LIMIT $3`,
+		status)
+}
+
+func (h *StoreHandler) UpdateOrderStatus(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || orderID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid order id"})
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || !orderStatuses[req.Status] {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	var (
+		current paidOrder
+		order   Order
+	)
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		err := tx.Get(&current, `SELECT `+paidOrderColumns+` FROM orders WHERE id = $1 FOR UPDATE`, orderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errOrderNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if !canTransition(current.Status, req.Status) {
+			return errInvalidTransition
+		}
+
+		if req.Status == OrderCancelled {
+			if err := cancelOrder(tx, current); err != nil {
+				return err
+			}
+		}
+
+		err = tx.Get(&order, `
+			UPDATE orders SET status = $1, updated_at = now() WHERE id = $2
+			RETURNING `+orderColumns, req.Status, orderID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO order_events (order_id, from_status, to_status, actor_id) VALUES ($1, $2, $3, $4)`,
+			orderID, current.Status, req.Status, c.GetInt("userID"))
+		return err
+	})
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, order)
+	case errors.Is(err, errOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Order not found"})
+	case errors.Is(err, errInvalidTransition):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Cannot move order from " + current.Status + " to " + req.Status})
+	case errors.Is(err, errNotEnoughItems):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Order items were handed over to another user"})
+	case errors.Is(err, errOrderNotRefundable):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Order predates the ledger and cannot be refunded"})
+	default:
+		log.Printf("[ERR] failed to update order status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to update order status"})
+	}
+}
+
+// cancelOrder refunds the buyer and puts limited items back on the shelf.
+// Items the holder has already handed over to someone else cannot come back.
+func cancelOrder(tx *sqlx.Tx, order paidOrder) error {
+	if err := lockOrderItems(tx, order.UserID, order.Order, order.Quantity); err != nil {
+		return err
+	}
+
+	amount := order.UnitPrice * order.Quantity
+	if err := refundOrder(tx, order, amount, "order:"+strconv.Itoa(order.ID), "Cancellation of "+order.Item); err != nil {
+		return err
+	}
+
+	return restock(tx, order.Order, order.Quantity)
+}
+
+// refundOrder pays amount of an order back to the buyer and records it in
+// their coin history. Backfilled orders never paid into the store account,
+// so there is nothing to refund them from.
+func refundOrder(tx *sqlx.Tx, order paidOrder, amount int, reference, message string) error {
+	if amount == 0 {
+		return nil
+	}
+	if order.LedgerTxnID == nil {
+		return errOrderNotRefundable
+	}
+
+	if _, err := ledger.Refund(tx, order.UserID, amount, reference); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO transactions (receiver_id, amount, message, kind) VALUES ($1, $2, $3, 'refund')`,
+		order.UserID, amount, message)
+	return err
+}
+
+// lockOrderItems checks that whoever holds the items of an order, the buyer
+// or the gift recipient, still has quantity of them.
+func lockOrderItems(tx *sqlx.Tx, userID int, order Order, quantity int) error {
+	holderID := userID
+	if order.RecipientID != nil {
+		holderID = *order.RecipientID
+	}
+
+	variant := ""
+	if order.Variant != nil {
+		variant = *order.Variant
+	}
+	return lockHolding(tx, holderID, order.Item, variant, quantity)
+}
+
+// restock puts quantity of an order back on the shelf, if the item or its
+// variant has a stock limit.
+func restock(tx *sqlx.Tx, order Order, quantity int) error {
+	if order.Variant != nil {
+		_, err := tx.Exec(`
+			UPDATE merch_variants SET stock = stock + $1 WHERE sku = $2 AND stock IS NOT NULL`,
+			quantity, *order.Variant)
+		return err
+	}
+
+	_, err := tx.Exec(`
+		UPDATE merch SET stock = stock + $1 WHERE name = $2 AND stock IS NOT NULL`,
+		quantity, order.Item)
+	return err
+}
@@ -0,0 +1,246 @@
+package store
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupDeskServer(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true))
+
+	handler := NewStoreHandler(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")}, nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userID", 99)
+		c.Next()
+	})
+	r.GET("/api/admin/orders", handler.ListAllOrders)
+	r.PATCH("/api/admin/orders/:id", handler.UpdateOrderStatus)
+	r.GET("/api/admin/returns", handler.ListReturns)
+	r.PATCH("/api/admin/returns/:id", handler.DecideReturn)
+
+	return r, mock
+}
+
+func expectLockOrder(mock sqlmock.Sqlmock, status string) {
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, item, variant, unit_price, list_price, promo_code, quantity, status, created_at, updated_at, recipient_id, gift_note, user_id, ledger_txn_id FROM orders WHERE id = \$1 FOR UPDATE`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "user_id", "ledger_txn_id")).AddRow(3, "cup", 20, 2, status, now, now, 1, 7))
+}
+
+func expectOrderUpdate(mock sqlmock.Sqlmock, from, to string) {
+	now := time.Now()
+	mock.ExpectQuery(`UPDATE orders SET status = \$1, updated_at = now\(\) WHERE id = \$2`).
+		WithArgs(to, 3).
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).AddRow(3, "cup", 20, 2, to, now, now))
+	mock.ExpectExec(`INSERT INTO order_events \(order_id, from_status, to_status, actor_id\)`).
+		WithArgs(3, from, to, 99).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+func expectHolding(mock sqlmock.Sqlmock, userID int, item, sku string, held int) {
+	mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM user_inventory WHERE user_id = \$1 AND item = \$2 AND variant = \$3`).
+		WithArgs(userID, item, sku).
+		WillReturnRows(sqlmock.NewRows([]string{"quantity"}).AddRow(held))
+}
+
+func TestUpdateOrderStatus_Advance(t *testing.T) {
+	r, mock := setupDeskServer(t)
+
+	expectLockOrder(mock, OrderPlaced)
+	expectOrderUpdate(mock, OrderPlaced, OrderApproved)
+
+	w := serve(r, http.MethodPatch, "/api/admin/orders/3", `{"status": "approved"}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"approved"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateOrderStatus_CancelRefunds(t *testing.T) {
+	r, mock := setupDeskServer(t)
+
+	expectLockOrder(mock, OrderReadyForPickup)
+	expectHolding(mock, 1, "cup", "", 2)
+	mock.ExpectQuery(`INSERT INTO ledger_txns`).
+		WithArgs("refund", "order:3").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(12, "system:store", -40).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs("user:1", 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(12, "user:1", 40).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(40, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO transactions \(receiver_id, amount, message, kind\) VALUES \(\$1, \$2, \$3, 'refund'\)`).
+		WithArgs(1, 40, "Cancellation of cup").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE merch SET stock = stock \+ \$1 WHERE name = \$2 AND stock IS NOT NULL`).
+		WithArgs(2, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectOrderUpdate(mock, OrderReadyForPickup, OrderCancelled)
+
+	w := serve(r, http.MethodPatch, "/api/admin/orders/3", `{"status": "cancelled"}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateOrderStatus_CancelRestocksVariant(t *testing.T) {
+	r, mock := setupDeskServer(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM orders WHERE id = \$1 FOR UPDATE`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "user_id", "variant")).
+			AddRow(3, "t-shirt", 0, 1, OrderPlaced, now, now, 1, "t-shirt-m"))
+	expectHolding(mock, 1, "t-shirt", "t-shirt-m", 1)
+	mock.ExpectExec(`UPDATE merch_variants SET stock = stock \+ \$1 WHERE sku = \$2 AND stock IS NOT NULL`).
+		WithArgs(1, "t-shirt-m").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectOrderUpdate(mock, OrderPlaced, OrderCancelled)
+
+	w := serve(r, http.MethodPatch, "/api/admin/orders/3", `{"status": "cancelled"}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateOrderStatus_CancelHandedOver(t *testing.T) {
+	r, mock := setupDeskServer(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM orders WHERE id = \$1 FOR UPDATE`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "user_id", "recipient_id")).
+			AddRow(3, "cup", 20, 2, OrderPlaced, now, now, 1, 2))
+	expectHolding(mock, 2, "cup", "", 1)
+	mock.ExpectRollback()
+
+	w := serve(r, http.MethodPatch, "/api/admin/orders/3", `{"status": "cancelled"}`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Order items were handed over to another user"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateOrderStatus_CancelBackfilled(t *testing.T) {
+	r, mock := setupDeskServer(t)
+	now := time.Now()
+
+	// Orders backfilled from user_merch were paid before the ledger, so the
+	// store account never received the coins to refund.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM orders WHERE id = \$1 FOR UPDATE`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "user_id", "ledger_txn_id")).
+			AddRow(3, "cup", 20, 2, OrderPlaced, now, now, 1, nil))
+	expectHolding(mock, 1, "cup", "", 2)
+	mock.ExpectRollback()
+
+	w := serve(r, http.MethodPatch, "/api/admin/orders/3", `{"status": "cancelled"}`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Order predates the ledger and cannot be refunded"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateOrderStatus_Guarded(t *testing.T) {
+	r, mock := setupDeskServer(t)
+
+	tests := []struct {
+		name           string
+		from           string
+		to             string
+		expectedStatus int
+	}{
+		{name: "Skipping a step", from: OrderPlaced, to: OrderDelivered, expectedStatus: http.StatusConflict},
+		{name: "Reopening a delivered order", from: OrderDelivered, to: OrderPlaced, expectedStatus: http.StatusConflict},
+		{name: "Cancelling twice", from: OrderCancelled, to: OrderCancelled, expectedStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectLockOrder(mock, tt.from)
+			mock.ExpectRollback()
+
+			w := serve(r, http.MethodPatch, "/api/admin/orders/3", `{"status": "`+tt.to+`"}`)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM orders WHERE id = \$1 FOR UPDATE`).
+		WithArgs(3).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	w := serve(r, http.MethodPatch, "/api/admin/orders/3", `{"status": "approved"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(r, http.MethodPatch, "/api/admin/orders/3", `{"status": "lost"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListAllOrders(t *testing.T) {
+	r, mock := setupDeskServer(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM orders o JOIN users u ON u.id = o.user_id`).
+		WithArgs(OrderApproved, 0, defaultOrdersPageSize+1).
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "user_name")).
+			AddRow(3, "cup", 20, 1, OrderApproved, now, now, "alice"))
+
+	w := serve(r, http.MethodGet, "/api/admin/orders?status=approved", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"user":"alice"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectQuery(`FROM orders o JOIN users u ON u.id = o.user_id`).
+		WithArgs("", 9, 2).
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "user_name")).
+			AddRow(8, "cup", 20, 1, OrderPlaced, now, now, "alice").
+			AddRow(6, "pen", 10, 1, OrderPlaced, now, now, "bob"))
+
+	w = serve(r, http.MethodGet, "/api/admin/orders?cursor=9&limit=1", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"nextCursor":"8"`)
+	assert.NotContains(t, w.Body.String(), `"user":"bob"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w = serve(r, http.MethodGet, "/api/admin/orders?limit=101", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
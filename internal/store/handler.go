@@ -1,31 +1,59 @@
 package store
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jamsi-max/merch-store/internal/ledger"
+	"github.com/jmoiron/sqlx"
 )
 
 const (
 	green = "\033[32m"
 	red   = "\033[31m"
 	reset = "\033[0m"
+
+	buyEndpoint = "buy"
 )
 
+var (
+	errInsufficientFunds = errors.New("insufficient funds")
+	errOutOfStock        = errors.New("out of stock")
+
+	errItemNotFound    = errors.New("item not found")
+	errVariantRequired = errors.New("variant required")
+	errVariantNotFound = errors.New("variant not found")
+	errPriceTooLow     = errors.New("price below one coin")
+)
+
+var quoteMessages = map[error]string{
+	errItemNotFound:    "Item not found",
+	errVariantRequired: "Variant required",
+	errVariantNotFound: "Variant not found",
+	errPriceTooLow:     "Item price is below 1 coin",
+}
+
 type StoreHandler struct {
-	db           *db.Database
-	MerchCatalog map[string]int
+	db          *db.Database
+	idempotency *idempotency.Store
+	Catalog     *Catalog
 }
 
-func NewStoreHandler(db *db.Database) *StoreHandler {
+func NewStoreHandler(db *db.Database, idempotency *idempotency.Store) *StoreHandler {
 	handler := &StoreHandler{
-		db:           db,
-		MerchCatalog: make(map[string]int),
+		db:          db,
+		idempotency: idempotency,
+		Catalog:     NewCatalog(db),
 	}
 
-	if err := handler.loadMerchCatalog(); err != nil {
+	if err := handler.Catalog.Reload(); err != nil {
 		log.Fatalf(red+"[ERR]"+reset+"couldn't load the merch catalog: %v", err)
 	}
 
@@ -33,83 +61,212 @@ func NewStoreHandler(db *db.Database) *StoreHandler {
 	return handler
 }
 
-func (h *StoreHandler) loadMerchCatalog() error {
-	rows, err := h.db.DB.Query("SELECT name, price FROM merch")
+func (h *StoreHandler) BuyItem(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Unauthorized"})
+		return
+	}
+
+	idempotencyKey, err := idempotency.KeyFromRequest(c)
 	if err != nil {
-		return err
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid Idempotency-Key"})
+		return
+	}
+
+	// Purchases without a variant or promo code keep hashing the bare item
+	// name, so keys stored before those existed still replay.
+	line := CartLine{Item: c.Param("item"), Variant: c.Query("variant"), Quantity: 1}
+	promoCode := normalizePromoCode(c.Query("promo"))
+	request := line.reference()
+	if promoCode != "" {
+		request += "?promo=" + promoCode
+	}
+	idempotent, done := h.idempotency.Begin(c, idempotencyKey, buyEndpoint, request)
+	if done {
+		return
 	}
-	defer rows.Close()
 
-	var name string
-	var price int
-	for rows.Next() {
-		if err := rows.Scan(&name, &price); err != nil {
+	line.UnitPrice, err = h.quote(line.Item, line.Variant)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": quoteMessages[err]})
+		return
+	}
+
+	var (
+		order Order
+		body  []byte
+	)
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		if err := idempotent.Claim(tx); err != nil {
+			return err
+		}
+
+		promo, total, err := applyPromo(tx, c.GetInt("userID"), promoCode, []CartLine{line})
+		if err != nil {
+			return err
+		}
+
+		if _, err := lockBalance(tx, userID, total); err != nil {
 			return err
 		}
-		h.MerchCatalog[name] = price
+
+		order, err = placeOrder(tx, c.GetInt("userID"), line, promo, nil)
+		if err != nil {
+			return err
+		}
+
+		if body, err = json.Marshal(order); err != nil {
+			return err
+		}
+
+		return idempotent.Save(tx, http.StatusOK, body)
+	})
+
+	switch {
+	case err == nil:
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	case errors.Is(err, errInsufficientFunds), db.IsCheckViolation(err):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Insufficient funds"})
+	case errors.Is(err, errOutOfStock):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Out of stock"})
+	case promoMessages[err] != "":
+		c.JSON(http.StatusBadRequest, gin.H{"errors": promoMessages[err]})
+	case idempotent.Finish(c, err):
+	default:
+		log.Printf("[ERR] transaction store failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Transaction store failed"})
+	}
+}
+
+// lockBalance checks the buyer balance under a row lock, so concurrent
+// purchases are serialized instead of racing past the check. It returns the
+// balance before the purchase.
+func lockBalance(tx *sqlx.Tx, userID interface{}, amount int) (int, error) {
+	var coins int
+	err := tx.Get(&coins, "SELECT coins FROM users WHERE id=$1 FOR UPDATE", userID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && coins < amount {
+		return 0, errInsufficientFunds
 	}
 
-	return rows.Err()
+	return coins, err
 }
 
-func (h *StoreHandler) BuyItem(c *gin.Context) {
-	item := c.Param("item")
+// quote prices one unit of an item, or of the chosen variant of it, from
+// the catalog. Nothing is sold for less than a coin: a variant that a sale
+// pushed below that is refused rather than left to the orders check.
+func (h *StoreHandler) quote(item, sku string) (int, error) {
+	merch, ok := h.Catalog.Get(item)
+	if !ok || !merch.Active {
+		return 0, errItemNotFound
+	}
 
-	price, ok := h.MerchCatalog[item]
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"errors": "Item not found"})
-		return
+	variant, ok := merch.Variant(sku)
+	switch {
+	case sku == "" && merch.HasVariants():
+		return 0, errVariantRequired
+	case sku != "" && (!ok || !variant.Active):
+		return 0, errVariantNotFound
 	}
 
-	userID, ok := c.Get("userID")
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Unauthorized"})
-		return
+	price := merch.PriceAt(time.Now()) + variant.PriceDelta
+	if price < 1 {
+		return 0, errPriceTooLow
 	}
 
-	var userCoins int
-	err := h.db.DB.Get(&userCoins, "SELECT coins FROM users WHERE id=$1", userID)
-	if err != nil || price > userCoins {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds"})
-		return
+	return price, nil
+}
+
+// gift sends an order to another user; the buyer pays for it.
+type gift struct {
+	recipientID int
+	note        string
+}
+
+// placeOrder takes the line out of stock, charges the buyer at the price
+// after promo, if any, and records the order, as a gift when one is given.
+// The caller has already locked and checked the buyer balance.
+func placeOrder(tx *sqlx.Tx, userID int, line CartLine, promo *Promo, gift *gift) (Order, error) {
+	var order Order
+	if err := takeStock(tx, line.Item, line.Variant, line.Quantity); err != nil {
+		return order, err
+	}
+
+	price := promo.price(line.Item, line.UnitPrice)
+	promoCode := ""
+	if promo.appliesTo(line.Item) {
+		promoCode = promo.Code
+	}
+
+	var (
+		recipientID *int
+		note        string
+	)
+	if gift != nil {
+		recipientID, note = &gift.recipientID, gift.note
 	}
 
-	tx, err := h.db.DB.Begin()
+	txnID, err := ledger.Purchase(tx, userID, price*line.Quantity, line.reference())
 	if err != nil {
-		log.Printf("[ERR] transaction store failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction store failed"})
-		return
+		return order, err
+	}
+
+	err = tx.Get(&order, `
+		INSERT INTO orders (user_id, item, variant, unit_price, list_price, promo_code, quantity, ledger_txn_id, recipient_id, gift_note)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, ''), $7, $8, $9, $10)
+		RETURNING `+orderColumns,
+		userID, line.Item, line.Variant, price, line.UnitPrice, promoCode, line.Quantity, txnID, recipientID, note)
+	return order, err
+}
+
+// applyPromo locks and redeems the code for a purchase of lines and returns
+// the promo together with the total to charge. An empty code is no promo.
+func applyPromo(tx *sqlx.Tx, userID int, code string, lines []CartLine) (*Promo, int, error) {
+	if code == "" {
+		total, _ := discountedTotal(lines, nil)
+		return nil, total, nil
 	}
 
-	_, err = tx.Exec("UPDATE users SET coins = coins - $1 WHERE id = $2", price, userID)
+	promo, err := lockPromo(tx, userID, code)
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			log.Printf("[ERR] failed to rollback transaction: %v", rbErr)
-		}
-		log.Printf("[ERR] failed to update balance: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balance"})
-		return
+		return nil, 0, err
 	}
 
-	_, err = tx.Exec(`
-		INSERT INTO user_merch (user_id, item, quantity) 
-		VALUES ($1, $2, 1) 
-		ON CONFLICT (user_id, item) 
-		DO UPDATE SET quantity = user_merch.quantity + 1`, userID, item)
+	total, discount := discountedTotal(lines, promo)
+	if err := redeemPromo(tx, userID, promo, discount); err != nil {
+		return nil, 0, err
+	}
+
+	return promo, total, nil
+}
+
+// takeStock decrements a limited item, or the chosen variant of it, under a
+// row lock; anything without a stock limit is left untouched.
+func takeStock(tx *sqlx.Tx, item, sku string, quantity int) error {
+	var (
+		stock *int
+		err   error
+	)
+	if sku == "" {
+		err = tx.Get(&stock, "SELECT stock FROM merch WHERE name = $1 FOR UPDATE", item)
+	} else {
+		err = tx.Get(&stock, "SELECT stock FROM merch_variants WHERE sku = $1 FOR UPDATE", sku)
+	}
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			log.Printf("[ERR] failed to rollback transaction: %v", rbErr)
-		}
-		log.Printf("[ERR] failed to update user merch: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user merch"})
-		return
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("[ERR] failed to commit transaction: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
-		return
+	switch {
+	case stock == nil:
+		return nil
+	case *stock < quantity:
+		return errOutOfStock
 	}
 
-	c.Status(http.StatusOK)
+	if sku == "" {
+		_, err = tx.Exec("UPDATE merch SET stock = stock - $1 WHERE name = $2", quantity, item)
+	} else {
+		_, err = tx.Exec("UPDATE merch_variants SET stock = stock - $1 WHERE sku = $2", quantity, sku)
+	}
+	return err
 }
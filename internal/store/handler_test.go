@@ -5,9 +5,14 @@ import (
 	"database/sql"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jamsi-max/merch-store/config"
 	"github.com/jamsi-max/merch-store/internal/auth"
 	"github.com/jamsi-max/merch-store/internal/db"
 	"github.com/jamsi-max/merch-store/internal/router"
@@ -20,7 +25,8 @@ import (
 
 const testJWTSecret = "testsecret"
 
-func setupTestDB(t *testing.T) *db.Database {
+// startTestDB starts an empty Postgres container for the test.
+func startTestDB(t *testing.T) *sql.DB {
 	ctx := context.Background()
 	req := testcontainers.ContainerRequest{
 		Image:        "postgres:13",
@@ -63,7 +69,13 @@ func setupTestDB(t *testing.T) *db.Database {
 
 	time.Sleep(5 * time.Second)
 
-	_, err = dbConn.Exec(`
+	return dbConn
+}
+
+func setupTestDB(t *testing.T) *db.Database {
+	dbConn := startTestDB(t)
+
+	_, err := dbConn.Exec(`
         CREATE TABLE users (
             id SERIAL PRIMARY KEY,
             name TEXT NOT NULL,
@@ -74,14 +86,31 @@ func setupTestDB(t *testing.T) *db.Database {
         CREATE TABLE merch (
             id SERIAL PRIMARY KEY,
             name TEXT NOT NULL,
-            price INT NOT NULL
+            price INT NOT NULL,
+            description TEXT NOT NULL DEFAULT '',
+            category TEXT NOT NULL DEFAULT '',
+            active BOOLEAN NOT NULL DEFAULT true,
+            stock INT
         );
 
-        CREATE TABLE user_merch (
-            user_id INT REFERENCES users(id),
+        CREATE TABLE merch_variants (
+            id SERIAL PRIMARY KEY,
             item TEXT NOT NULL,
-            quantity INT NOT NULL,
-            PRIMARY KEY (user_id, item)
+            sku TEXT NOT NULL UNIQUE,
+            size TEXT NOT NULL DEFAULT '',
+            color TEXT NOT NULL DEFAULT '',
+            price_delta INT NOT NULL DEFAULT 0,
+            stock INT,
+            active BOOLEAN NOT NULL DEFAULT true
+        );
+
+        CREATE TABLE price_rules (
+            id SERIAL PRIMARY KEY,
+            item TEXT NOT NULL,
+            label TEXT NOT NULL DEFAULT '',
+            sale_price INT NOT NULL,
+            starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            ends_at TIMESTAMP WITH TIME ZONE
         );
 
         CREATE TABLE transactions (
@@ -91,6 +120,41 @@ func setupTestDB(t *testing.T) *db.Database {
             quantity INT NOT NULL,
             total_price INT NOT NULL
         );
+
+        CREATE TABLE orders (
+            id SERIAL PRIMARY KEY,
+            user_id INT NOT NULL REFERENCES users(id),
+            item TEXT NOT NULL,
+            variant TEXT,
+            unit_price INT NOT NULL,
+            list_price INT NOT NULL DEFAULT 0,
+            promo_code TEXT,
+            quantity INT NOT NULL DEFAULT 1,
+            status TEXT NOT NULL DEFAULT 'placed',
+            ledger_txn_id INT,
+            recipient_id INT,
+            gift_note TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+            updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+        );
+
+        CREATE TABLE ledger_accounts (
+            code TEXT PRIMARY KEY,
+            user_id INT UNIQUE REFERENCES users(id)
+        );
+
+        CREATE TABLE ledger_txns (
+            id SERIAL PRIMARY KEY,
+            kind TEXT NOT NULL,
+            reference TEXT NOT NULL DEFAULT ''
+        );
+
+        CREATE TABLE ledger_entries (
+            id SERIAL PRIMARY KEY,
+            txn_id INT NOT NULL REFERENCES ledger_txns(id),
+            account TEXT NOT NULL REFERENCES ledger_accounts(code),
+            amount INT NOT NULL
+        );
     `)
 	if err != nil {
 		t.Fatalf("Failed to create tables: %v", err)
@@ -99,6 +163,7 @@ func setupTestDB(t *testing.T) *db.Database {
 	_, err = dbConn.Exec(`
         INSERT INTO users (name, pass, coins) VALUES ('testuser', 'password', 1000);
         INSERT INTO merch (name, price) VALUES ('t-shirt', 500);
+        INSERT INTO ledger_accounts (code, user_id) VALUES ('system:store', NULL), ('user:1', 1);
     `)
 	if err != nil {
 		t.Fatalf("Failed to prepare test database: %v", err)
@@ -110,9 +175,9 @@ func setupTestDB(t *testing.T) *db.Database {
 
 func TestBuyItemE2E(t *testing.T) {
 	db := setupTestDB(t)
-	r := router.SetupRouter(db, testJWTSecret)
+	r := router.SetupRouter(context.Background(), db, &config.Config{JWTSecret: testJWTSecret, LegacyBuyRoute: true})
 
-	token, err := auth.GenerateToken(1, "testuser", testJWTSecret)
+	token, err := auth.GenerateToken(auth.NewHMACKeyRing(testJWTSecret), 1, "testuser", auth.RoleEmployee, time.Hour)
 	if err != nil {
 		t.Fatalf("Failed to generate token: %v", err)
 	}
@@ -130,8 +195,72 @@ func TestBuyItemE2E(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 500, newBalance)
 
-	var quantity int
-	err = db.DB.QueryRow("SELECT quantity FROM user_merch WHERE user_id=1 AND item='t-shirt'").Scan(&quantity)
+	var order struct {
+		UnitPrice int    `db:"unit_price"`
+		Quantity  int    `db:"quantity"`
+		Status    string `db:"status"`
+	}
+	err = db.DB.Get(&order, "SELECT unit_price, quantity, status FROM orders WHERE user_id=1 AND item='t-shirt'")
 	assert.NoError(t, err)
-	assert.Equal(t, 1, quantity)
+	assert.Equal(t, 500, order.UnitPrice)
+	assert.Equal(t, 1, order.Quantity)
+	assert.Equal(t, "placed", order.Status)
+}
+
+// setupMigratedDB applies the Up section of every migration in order, as
+// goose would, so the test sees the schema and seed data production has.
+func setupMigratedDB(t *testing.T) *db.Database {
+	dbConn := startTestDB(t)
+
+	files, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Failed to find migrations: %v", err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read migration %s: %v", file, err)
+		}
+
+		up, _, _ := strings.Cut(string(migration), "-- +goose Down")
+		if _, err := dbConn.Exec(up); err != nil {
+			t.Fatalf("Failed to apply migration %s: %v", file, err)
+		}
+	}
+
+	return &db.Database{DB: sqlx.NewDb(dbConn, "postgres")}
+}
+
+// TestBuyItemE2E_MigratedSchema buys seeded items without a SKU, as clients
+// did before variants existed.
+func TestBuyItemE2E_MigratedSchema(t *testing.T) {
+	db := setupMigratedDB(t)
+	r := router.SetupRouter(context.Background(), db, &config.Config{JWTSecret: testJWTSecret, LegacyBuyRoute: true})
+
+	token, err := auth.GenerateToken(auth.NewHMACKeyRing(testJWTSecret), 1, "Иван Иванов", auth.RoleEmployee, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	requests := []struct {
+		method, path, body string
+		expectedStatus     int
+	}{
+		{method: http.MethodGet, path: "/api/buy/t-shirt", expectedStatus: http.StatusOK},
+		{method: http.MethodPost, path: "/api/purchases", body: `{"item": "hoody"}`, expectedStatus: http.StatusCreated},
+		{method: http.MethodPost, path: "/api/cart", body: `{"item": "pink-hoody", "quantity": 1}`, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range requests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expectedStatus, w.Code, "%s %s: %s", tt.method, tt.path, w.Body.String())
+	}
 }
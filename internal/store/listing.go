@@ -0,0 +1,183 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CatalogItem.Price is the price right now; OriginalPrice is the regular
+// price, which differs while a sale is on.
+type CatalogItem struct {
+	Name          string           `json:"name"`
+	Price         int              `json:"price"`
+	OriginalPrice int              `json:"originalPrice"`
+	Description   string           `json:"description"`
+	Category      string           `json:"category"`
+	Stock         *int             `json:"stock"`
+	Available     bool             `json:"available"`
+	Sale          *CatalogSale     `json:"sale,omitempty"`
+	UpcomingSales []CatalogSale    `json:"upcomingSales,omitempty"`
+	Variants      []CatalogVariant `json:"variants,omitempty"`
+}
+
+type CatalogSale struct {
+	Label     string     `json:"label,omitempty"`
+	SalePrice int        `json:"salePrice"`
+	StartsAt  time.Time  `json:"startsAt"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`
+}
+
+func newCatalogSale(rule PriceRule) CatalogSale {
+	return CatalogSale{Label: rule.Label, SalePrice: rule.SalePrice, StartsAt: rule.StartsAt, EndsAt: rule.EndsAt}
+}
+
+type CatalogVariant struct {
+	SKU       string `json:"sku"`
+	Size      string `json:"size,omitempty"`
+	Color     string `json:"color,omitempty"`
+	Price     int    `json:"price"`
+	Stock     *int   `json:"stock"`
+	Available bool   `json:"available"`
+}
+
+func newCatalogItem(item MerchItem, now time.Time) CatalogItem {
+	catalogItem := CatalogItem{
+		Name:          item.Name,
+		Price:         item.PriceAt(now),
+		OriginalPrice: item.Price,
+		Description:   item.Description,
+		Category:      item.Category,
+		Stock:         item.Stock,
+		Available:     item.Active && item.InStock(),
+	}
+
+	if rule, ok := item.PriceRule(now); ok {
+		sale := newCatalogSale(rule)
+		catalogItem.Sale = &sale
+	}
+	for _, rule := range item.PriceRules {
+		if rule.StartsAt.After(now) {
+			catalogItem.UpcomingSales = append(catalogItem.UpcomingSales, newCatalogSale(rule))
+		}
+	}
+
+	for _, variant := range item.Variants {
+		if !variant.Active {
+			continue
+		}
+
+		catalogItem.Variants = append(catalogItem.Variants, CatalogVariant{
+			SKU:       variant.SKU,
+			Size:      variant.Size,
+			Color:     variant.Color,
+			Price:     catalogItem.Price + variant.PriceDelta,
+			Stock:     variant.Stock,
+			Available: item.Active && variant.InStock(),
+		})
+	}
+
+	return catalogItem
+}
+
+var catalogSorts = map[string]func(a, b CatalogItem) bool{
+	"name":   func(a, b CatalogItem) bool { return a.Name < b.Name },
+	"-name":  func(a, b CatalogItem) bool { return a.Name > b.Name },
+	"price":  func(a, b CatalogItem) bool { return a.Price < b.Price || a.Price == b.Price && a.Name < b.Name },
+	"-price": func(a, b CatalogItem) bool { return a.Price > b.Price || a.Price == b.Price && a.Name < b.Name },
+}
+
+func (h *StoreHandler) ListItems(c *gin.Context) {
+	less, ok := catalogSorts[c.DefaultQuery("sort", "name")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid sort"})
+		return
+	}
+
+	minPrice, err := priceParam(c, "min_price", 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid min_price"})
+		return
+	}
+
+	maxPrice, err := priceParam(c, "max_price", -1)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid max_price"})
+		return
+	}
+
+	category := c.Query("category")
+	now := time.Now()
+
+	items := []CatalogItem{}
+	for _, merch := range h.Catalog.Items() {
+		price := merch.PriceAt(now)
+		switch {
+		case !merch.Active:
+		case price < minPrice:
+		case maxPrice >= 0 && price > maxPrice:
+		case category != "" && merch.Category != category:
+		default:
+			items = append(items, newCatalogItem(merch, now))
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return less(items[i], items[j]) })
+
+	writeWithETag(c, gin.H{"items": items})
+}
+
+func (h *StoreHandler) GetItem(c *gin.Context) {
+	merch, ok := h.Catalog.Get(c.Param("item"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Item not found"})
+		return
+	}
+
+	writeWithETag(c, newCatalogItem(merch, time.Now()))
+}
+
+func priceParam(c *gin.Context, name string, fallback int) (int, error) {
+	raw, ok := c.GetQuery(name)
+	if !ok {
+		return fallback, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, strconv.ErrSyntax
+	}
+
+	return value, nil
+}
+
+// writeWithETag answers 304 when the client already holds the same
+// representation, so the frontend can poll the catalog cheaply.
+func writeWithETag(c *gin.Context, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[ERR] failed to encode catalog: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to encode catalog"})
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, no-cache")
+
+	if match := c.GetHeader("If-None-Match"); match == etag || match == "W/"+etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
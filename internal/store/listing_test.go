@@ -0,0 +1,119 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupListingServer(t *testing.T) *gin.Engine {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	expectCatalogLoad(mock, sqlmock.NewRows(append(catalogColumns, "stock")).
+		AddRow("cup", 20, "Coffee cup", "accessories", true, nil).
+		AddRow("hoody", 300, "Warm hoody", "clothing", true, 5).
+		AddRow("socks", 10, "Striped socks", "clothing", true, 0).
+		AddRow("umbrella", 200, "", "accessories", false, nil))
+
+	handler := NewStoreHandler(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")}, nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.GET("/api/merch", handler.ListItems)
+	r.GET("/api/merch/:item", handler.GetItem)
+	return r
+}
+
+func listNames(t *testing.T, w *httptest.ResponseRecorder) []string {
+	var res struct {
+		Items []CatalogItem `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+
+	names := []string{}
+	for _, item := range res.Items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func TestListItems(t *testing.T) {
+	r := setupListingServer(t)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedNames  []string
+	}{
+		{name: "Default sort hides retired items", query: "", expectedStatus: http.StatusOK, expectedNames: []string{"cup", "hoody", "socks"}},
+		{name: "Sort by price descending", query: "?sort=-price", expectedStatus: http.StatusOK, expectedNames: []string{"hoody", "cup", "socks"}},
+		{name: "Price range", query: "?min_price=15&max_price=300&sort=price", expectedStatus: http.StatusOK, expectedNames: []string{"cup", "hoody"}},
+		{name: "Category", query: "?category=clothing", expectedStatus: http.StatusOK, expectedNames: []string{"hoody", "socks"}},
+		{name: "Invalid sort", query: "?sort=color", expectedStatus: http.StatusBadRequest},
+		{name: "Invalid price", query: "?min_price=-1", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, "/api/merch"+tt.query, "")
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedNames != nil {
+				assert.Equal(t, tt.expectedNames, listNames(t, w))
+			}
+		})
+	}
+}
+
+func TestListItems_ETag(t *testing.T) {
+	r := setupListingServer(t)
+
+	w := serve(r, http.MethodGet, "/api/merch", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/merch", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+}
+
+func TestGetItem(t *testing.T) {
+	r := setupListingServer(t)
+
+	w := serve(r, http.MethodGet, "/api/merch/umbrella", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var item CatalogItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+	assert.Equal(t, "umbrella", item.Name)
+	assert.False(t, item.Available)
+
+	w = serve(r, http.MethodGet, "/api/merch/socks", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	item = CatalogItem{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+	assert.False(t, item.Available)
+	require.NotNil(t, item.Stock)
+	assert.Equal(t, 0, *item.Stock)
+
+	w = serve(r, http.MethodGet, "/api/merch/ghost", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+)
+
+const (
+	OrderPlaced         = "placed"
+	OrderApproved       = "approved"
+	OrderReadyForPickup = "ready_for_pickup"
+	OrderDelivered      = "delivered"
+	OrderCancelled      = "cancelled"
+
+	defaultOrdersPageSize = 20
+	maxOrdersPageSize     = 100
+)
+
+const orderColumns = "id, item, variant, unit_price, list_price, promo_code, quantity, status, created_at, updated_at, recipient_id, gift_note"
+
+type Order struct {
+	ID        int       `json:"id" db:"id"`
+	Item      string    `json:"item" db:"item"`
+	Variant   *string   `json:"variant,omitempty" db:"variant"`
+	UnitPrice int       `json:"unitPrice" db:"unit_price"`
+	ListPrice int       `json:"listPrice" db:"list_price"`
+	PromoCode *string   `json:"promoCode,omitempty" db:"promo_code"`
+	Quantity  int       `json:"quantity" db:"quantity"`
+	Status    string    `json:"status" db:"status"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+
+	// RecipientID is set on gifts bought for another user.
+	RecipientID *int   `json:"recipientId,omitempty" db:"recipient_id"`
+	GiftNote    string `json:"giftNote,omitempty" db:"gift_note"`
+}
+
+var orderStatuses = map[string]bool{
+	OrderPlaced:         true,
+	OrderApproved:       true,
+	OrderReadyForPickup: true,
+	OrderDelivered:      true,
+	OrderCancelled:      true,
+}
+
+func (h *StoreHandler) ListOrders(c *gin.Context) {
+	if _, ok := c.Get("userID"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Unauthorized"})
+		return
+	}
+
+	status := c.Query("status")
+	if status != "" && !orderStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid status"})
+		return
+	}
+
+	pageOrders[Order](c, h.db, `
+		SELECT `+orderColumns+` FROM orders
+		WHERE user_id = $1 AND ($2 = '' OR status = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4`,
+		c.GetInt("userID"), status)
+}
+
+func (o Order) orderID() int {
+	return o.ID
+}
+
+// pageOrders lists one page of orders, newest first, for ListOrders and
+// ListAllOrders. The cursor and the page size are appended to args, so
+// query ends with ($n = 0 OR id < $n) and LIMIT $n+1 in that order.
+func pageOrders[T interface{ orderID() int }](c *gin.Context, database *db.Database, query string, args ...interface{}) {
+	cursor, limit, ok := orderPageParams(c)
+	if !ok {
+		return
+	}
+
+	orders := []T{}
+	if err := database.DB.Select(&orders, query, append(args, cursor, limit+1)...); err != nil {
+		log.Printf("[ERR] failed to list orders: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to list orders"})
+		return
+	}
+
+	res := gin.H{"orders": orders}
+	if len(orders) > limit {
+		res["orders"] = orders[:limit]
+		res["nextCursor"] = strconv.Itoa(orders[limit-1].orderID())
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// orderPageParams reads the cursor and limit query parameters; when they
+// are invalid it has already answered 400.
+func orderPageParams(c *gin.Context) (cursor, limit int, ok bool) {
+	if raw, found := c.GetQuery("cursor"); found {
+		var err error
+		cursor, err = strconv.Atoi(raw)
+		if err != nil || cursor <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid cursor"})
+			return 0, 0, false
+		}
+	}
+
+	limit = defaultOrdersPageSize
+	if raw, found := c.GetQuery("limit"); found {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxOrdersPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid limit"})
+			return 0, 0, false
+		}
+	}
+
+	return cursor, limit, true
+}
@@ -0,0 +1,239 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var orderRowColumns = []string{"id", "item", "unit_price", "quantity", "status", "created_at", "updated_at"}
+
+func setupOrdersServer(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).
+		AddRow("cup", 20, "", "accessories", true).
+		AddRow("umbrella", 200, "", "accessories", false).
+		AddRow("t-shirt", 80, "", "clothing", true),
+		sqlmock.NewRows(catalogVariantColumns).
+			AddRow("t-shirt", "t-shirt-m", "m", "", 0, 5, true).
+			AddRow("t-shirt", "t-shirt-xl", "xl", "", 10, 0, true).
+			AddRow("t-shirt", "t-shirt-xs", "xs", "", 0, nil, false))
+
+	database := &db.Database{DB: sqlx.NewDb(mockDB, "postgres")}
+	handler := NewStoreHandler(database, idempotency.NewStore(database, time.Hour))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userID", 1)
+		c.Next()
+	})
+	r.GET("/api/buy/:item", handler.BuyItem)
+	r.GET("/api/orders", handler.ListOrders)
+	r.GET("/api/cart", handler.GetCart)
+	r.POST("/api/cart", handler.AddToCart)
+	r.DELETE("/api/cart/:item", handler.RemoveFromCart)
+	r.POST("/api/checkout", handler.Checkout)
+	r.POST("/api/purchases", handler.CreatePurchase)
+	r.POST("/api/item-transfers", handler.TransferItems)
+	r.POST("/api/orders/:id/returns", handler.RequestReturn)
+
+	return r, mock
+}
+
+func expectPurchase(mock sqlmock.Sqlmock, coins int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id=\$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(coins))
+}
+
+func TestBuyItem_CreatesOrder(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	expectPurchase(mock, 100)
+	mock.ExpectQuery(`SELECT stock FROM merch WHERE name = \$1 FOR UPDATE`).
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(nil))
+	mock.ExpectQuery(`INSERT INTO ledger_txns`).
+		WithArgs("purchase", "cup").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs("user:1", 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(9, "user:1", -20).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(-20, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(9, "system:store", 20).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`INSERT INTO orders \(user_id, item, variant, unit_price, list_price, promo_code, quantity, ledger_txn_id, recipient_id, gift_note\)`).
+		WithArgs(1, "cup", "", 20, 20, "", 1, 9, nil, "").
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).AddRow(3, "cup", 20, 1, "placed", now, now))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodGet, "/api/buy/cup", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var order Order
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &order))
+	assert.Equal(t, Order{ID: 3, Item: "cup", UnitPrice: 20, Quantity: 1, Status: OrderPlaced, CreatedAt: now, UpdatedAt: now}, order)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBuyItem_Rejected(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	w := serve(r, http.MethodGet, "/api/buy/umbrella", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	expectPurchase(mock, 10)
+	mock.ExpectRollback()
+
+	w = serve(r, http.MethodGet, "/api/buy/cup", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Insufficient funds"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBuyItem_Stock(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	expectPurchase(mock, 100)
+	mock.ExpectQuery(`SELECT stock FROM merch WHERE name = \$1 FOR UPDATE`).
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(0))
+	mock.ExpectRollback()
+
+	w := serve(r, http.MethodGet, "/api/buy/cup", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Out of stock"}`, w.Body.String())
+
+	expectPurchase(mock, 100)
+	mock.ExpectQuery(`SELECT stock FROM merch WHERE name = \$1 FOR UPDATE`).
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(1))
+	mock.ExpectExec(`UPDATE merch SET stock = stock - \$1 WHERE name = \$2`).
+		WithArgs(1, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO ledger_txns`).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	w = serve(r, http.MethodGet, "/api/buy/cup", "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBuyItem_Variant(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	sku := "t-shirt-xl"
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Variant missing", path: "/api/buy/t-shirt", expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Variant required"}`},
+		{name: "Unknown variant", path: "/api/buy/t-shirt?variant=cup", expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Variant not found"}`},
+		{name: "Retired variant", path: "/api/buy/t-shirt?variant=t-shirt-xs", expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Variant not found"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, tt.path, "")
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+
+	expectPurchase(mock, 100)
+	mock.ExpectQuery(`SELECT stock FROM merch_variants WHERE sku = \$1 FOR UPDATE`).
+		WithArgs(sku).
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(2))
+	mock.ExpectExec(`UPDATE merch_variants SET stock = stock - \$1 WHERE sku = \$2`).
+		WithArgs(1, sku).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO ledger_txns`).
+		WithArgs("purchase", "t-shirt/t-shirt-xl").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs("user:1", 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(9, "user:1", -90).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(-90, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(9, "system:store", 90).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`INSERT INTO orders`).
+		WithArgs(1, "t-shirt", sku, 90, 90, "", 1, 9, nil, "").
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "variant")).AddRow(4, "t-shirt", 90, 1, "placed", now, now, sku))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodGet, "/api/buy/t-shirt?variant=t-shirt-xl", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var order Order
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &order))
+	require.NotNil(t, order.Variant)
+	assert.Equal(t, sku, *order.Variant)
+	assert.Equal(t, 90, order.UnitPrice)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListOrders(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	mock.ExpectQuery(`SELECT id, item, variant, unit_price, list_price, promo_code, quantity, status, created_at, updated_at, recipient_id, gift_note FROM orders`).
+		WithArgs(1, "", 0, 3).
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).
+			AddRow(7, "cup", 20, 1, "placed", now, now).
+			AddRow(5, "pen", 10, 1, "placed", now, now).
+			AddRow(2, "book", 50, 1, "cancelled", now, now))
+
+	w := serve(r, http.MethodGet, "/api/orders?limit=2", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var res struct {
+		Orders     []Order `json:"orders"`
+		NextCursor string  `json:"nextCursor"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Len(t, res.Orders, 2)
+	assert.Equal(t, "5", res.NextCursor)
+
+	mock.ExpectQuery(`FROM orders`).
+		WithArgs(1, "cancelled", 5, defaultOrdersPageSize+1).
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).AddRow(2, "book", 50, 1, "cancelled", now, now))
+
+	w = serve(r, http.MethodGet, "/api/orders?status=cancelled&cursor=5", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(r, http.MethodGet, "/api/orders?status=lost", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *StoreHandler) ListPriceRules(c *gin.Context) {
+	rules := []PriceRule{}
+	err := h.db.DB.Select(&rules, `
+		SELECT `+priceRuleColumns+` FROM price_rules
+		WHERE ($1 = '' OR item = $1) AND (ends_at IS NULL OR ends_at > now())
+		ORDER BY starts_at, id`,
+		c.Query("item"))
+	if err != nil {
+		log.Printf("[ERR] failed to list price rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to list price rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"priceRules": rules})
+}
+
+func (h *StoreHandler) CreatePriceRule(c *gin.Context) {
+	var req struct {
+		Item      string     `json:"item" binding:"required"`
+		Label     string     `json:"label" binding:"max=64"`
+		SalePrice int        `json:"salePrice" binding:"required,min=1"`
+		StartsAt  time.Time  `json:"startsAt" binding:"required"`
+		EndsAt    *time.Time `json:"endsAt"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	switch {
+	case req.EndsAt != nil && !req.EndsAt.After(req.StartsAt):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Sale must end after it starts"})
+		return
+	case req.EndsAt != nil && !req.EndsAt.After(time.Now()):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Sale is already over"})
+		return
+	}
+
+	merch, ok := h.Catalog.Get(req.Item)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Item not found"})
+		return
+	}
+
+	// Variants are priced as an offset from the item price, so the sale
+	// price has to leave every variant at a coin or more.
+	for _, variant := range merch.Variants {
+		if req.SalePrice+variant.PriceDelta < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Sale price is too low for variant " + variant.SKU})
+			return
+		}
+	}
+
+	var rule PriceRule
+	err := h.db.DB.Get(&rule, `
+		INSERT INTO price_rules (item, label, sale_price, starts_at, ends_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+priceRuleColumns,
+		req.Item, req.Label, req.SalePrice, req.StartsAt, req.EndsAt, c.GetInt("userID"))
+	if err != nil {
+		log.Printf("[ERR] failed to create price rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to create price rule"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *StoreHandler) DeletePriceRule(c *gin.Context) {
+	ruleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || ruleID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid price rule id"})
+		return
+	}
+
+	res, err := h.db.DB.Exec("DELETE FROM price_rules WHERE id = $1", ruleID)
+	if err != nil {
+		log.Printf("[ERR] failed to delete price rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to delete price rule"})
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Price rule not found"})
+		return
+	}
+
+	h.reloadCatalog()
+	c.Status(http.StatusOK)
+}
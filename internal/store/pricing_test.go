@@ -0,0 +1,189 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupPricingServer(t *testing.T) (*gin.Engine, *StoreHandler, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	now := time.Now()
+	expectCatalogReload(mock,
+		sqlmock.NewRows(catalogColumns).
+			AddRow("cup", 20, "", "accessories", true).
+			AddRow("hoody", 300, "", "clothing", true),
+		sqlmock.NewRows(catalogVariantColumns),
+		sqlmock.NewRows(priceRuleRowColumns).
+			AddRow(1, "hoody", "", 280, now.Add(-48*time.Hour), nil).
+			AddRow(2, "hoody", "Hoody week", 200, now.Add(-time.Hour), now.Add(time.Hour)).
+			AddRow(3, "hoody", "Black Friday", 150, now.Add(24*time.Hour), now.Add(48*time.Hour)))
+
+	handler := NewStoreHandler(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")}, nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userID", 1)
+		c.Next()
+	})
+	r.GET("/api/merch", handler.ListItems)
+	r.GET("/api/merch/:item", handler.GetItem)
+	r.POST("/api/purchases", handler.CreatePurchase)
+	r.POST("/api/admin/price-rules", handler.CreatePriceRule)
+	r.PATCH("/api/admin/merch/:item", handler.UpdateItem)
+	r.POST("/api/admin/merch/:item/variants", handler.CreateVariant)
+	r.PATCH("/api/admin/merch/:item/variants/:sku", handler.UpdateVariant)
+
+	return r, handler, mock
+}
+
+func TestMerchItemPriceAt(t *testing.T) {
+	now := time.Now()
+	saleEnd := now.Add(time.Hour)
+	item := MerchItem{
+		Price: 300,
+		PriceRules: []PriceRule{
+			{SalePrice: 280, StartsAt: now.Add(-48 * time.Hour)},
+			{SalePrice: 200, StartsAt: now.Add(-time.Hour), EndsAt: &saleEnd},
+		},
+	}
+
+	assert.Equal(t, 300, item.PriceAt(now.Add(-72*time.Hour)))
+	assert.Equal(t, 280, item.PriceAt(now.Add(-2*time.Hour)))
+	assert.Equal(t, 200, item.PriceAt(now))
+	assert.Equal(t, 280, item.PriceAt(saleEnd))
+	assert.Equal(t, 20, MerchItem{Price: 20}.PriceAt(now))
+}
+
+func TestGetItem_Sale(t *testing.T) {
+	r, _, _ := setupPricingServer(t)
+
+	w := serve(r, http.MethodGet, "/api/merch/hoody", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var item CatalogItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+	assert.Equal(t, 200, item.Price)
+	assert.Equal(t, 300, item.OriginalPrice)
+	require.NotNil(t, item.Sale)
+	assert.Equal(t, "Hoody week", item.Sale.Label)
+	require.Len(t, item.UpcomingSales, 1)
+	assert.Equal(t, 150, item.UpcomingSales[0].SalePrice)
+
+	w = serve(r, http.MethodGet, "/api/merch?max_price=250", "")
+	assert.Equal(t, []string{"cup", "hoody"}, listNames(t, w))
+}
+
+func TestCreatePurchase_SalePrice(t *testing.T) {
+	r, _, mock := setupPricingServer(t)
+	now := time.Now()
+
+	expectPurchase(mock, 500)
+	mock.ExpectQuery(`SELECT stock FROM merch WHERE name = \$1 FOR UPDATE`).
+		WithArgs("hoody").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(nil))
+	expectLinePurchase(mock, 9, "hoody", 200)
+	mock.ExpectQuery(`INSERT INTO orders`).
+		WithArgs(1, "hoody", "", 200, 200, "", 1, 9, nil, "").
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).AddRow(4, "hoody", 200, 1, "placed", now, now))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodPost, "/api/purchases", `{"item": "hoody", "expectedPrice": 200}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w = serve(r, http.MethodPost, "/api/purchases", `{"item": "hoody", "expectedPrice": 300}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Price changed", "price": 200}`, w.Body.String())
+}
+
+func TestCreatePriceRule(t *testing.T) {
+	r, _, mock := setupPricingServer(t)
+	startsAt := time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC)
+	endsAt := startsAt.Add(7 * 24 * time.Hour)
+
+	mock.ExpectQuery(`INSERT INTO price_rules \(item, label, sale_price, starts_at, ends_at, created_by\)`).
+		WithArgs("cup", "Cup week", 15, startsAt, endsAt, 1).
+		WillReturnRows(sqlmock.NewRows(priceRuleRowColumns).AddRow(4, "cup", "Cup week", 15, startsAt, endsAt))
+	expectCatalogLoad(mock, sqlmock.NewRows(catalogColumns).AddRow("cup", 20, "", "accessories", true))
+
+	w := serve(r, http.MethodPost, "/api/admin/price-rules",
+		`{"item": "cup", "label": "Cup week", "salePrice": 15, "startsAt": "2030-03-01T00:00:00Z", "endsAt": "2030-03-08T00:00:00Z"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "Ends before it starts", body: `{"item": "cup", "salePrice": 15, "startsAt": "2030-03-08T00:00:00Z", "endsAt": "2030-03-01T00:00:00Z"}`, expectedStatus: http.StatusBadRequest},
+		{name: "Already over", body: `{"item": "cup", "salePrice": 15, "startsAt": "2020-03-01T00:00:00Z", "endsAt": "2020-03-08T00:00:00Z"}`, expectedStatus: http.StatusBadRequest},
+		{name: "Free item", body: `{"item": "cup", "salePrice": 0, "startsAt": "2030-03-01T00:00:00Z"}`, expectedStatus: http.StatusBadRequest},
+		{name: "Unknown item", body: `{"item": "lamp", "salePrice": 15, "startsAt": "2030-03-01T00:00:00Z"}`, expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/admin/price-rules", tt.body)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestVariantPrice_Sale(t *testing.T) {
+	r, handler, mock := setupPricingServer(t)
+	now := time.Now()
+
+	// Black Friday takes the hoody down to 150, so no variant may be priced
+	// more than 149 below the item, even though the sale has not started.
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{name: "New variant", method: http.MethodPost, path: "/api/admin/merch/hoody/variants", body: `{"sku": "hoody-xs", "priceDelta": -150}`},
+		{name: "Repriced variant", method: http.MethodPatch, path: "/api/admin/merch/hoody/variants/hoody-s", body: `{"priceDelta": -160}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, tt.method, tt.path, tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), "Variant price must be positive")
+		})
+	}
+
+	expectCatalogReload(mock,
+		sqlmock.NewRows(catalogColumns).AddRow("hoody", 300, "", "clothing", true),
+		sqlmock.NewRows(catalogVariantColumns).
+			AddRow("hoody", "hoody-s", "s", "", -100, nil, true).
+			AddRow("hoody", "hoody-xs", "xs", "", -220, nil, true),
+		sqlmock.NewRows(priceRuleRowColumns).
+			AddRow(2, "hoody", "Hoody week", 200, now.Add(-time.Hour), now.Add(time.Hour)))
+	require.NoError(t, handler.Catalog.Reload())
+
+	w := serve(r, http.MethodPatch, "/api/admin/merch/hoody", `{"price": 200}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Price is too low for variant hoody-xs"}`, w.Body.String())
+
+	// A variant priced before the check existed is refused at purchase
+	// instead of failing the orders check as insufficient funds.
+	w = serve(r, http.MethodPost, "/api/purchases", `{"item": "hoody", "variant": "hoody-xs"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Item price is below 1 coin"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// A percent promo takes Value percent off each unit; a fixed promo takes
+// Value coins off each unit, so it is worth Value for every unit bought it
+// applies to, not Value once per purchase.
+const (
+	PromoPercent = "percent"
+	PromoFixed   = "fixed"
+
+	promoColumns = "code, kind, value, item, max_uses, max_uses_per_user, uses, starts_at, ends_at, active"
+)
+
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+var (
+	errPromoInvalid       = errors.New("promo code is not valid")
+	errPromoExhausted     = errors.New("promo code is used up")
+	errPromoUsed          = errors.New("promo code already used")
+	errPromoNotApplicable = errors.New("promo code does not apply")
+)
+
+var promoMessages = map[error]string{
+	errPromoInvalid:       "Invalid promo code",
+	errPromoExhausted:     "Promo code is used up",
+	errPromoUsed:          "Promo code already used",
+	errPromoNotApplicable: "Promo code does not apply to this purchase",
+}
+
+// Promo is a discount code. Item is nil for store-wide codes; nil limits
+// and EndsAt mean unlimited.
+type Promo struct {
+	Code           string     `json:"code" db:"code"`
+	Kind           string     `json:"kind" db:"kind"`
+	Value          int        `json:"value" db:"value"`
+	Item           *string    `json:"item" db:"item"`
+	MaxUses        *int       `json:"maxUses" db:"max_uses"`
+	MaxUsesPerUser *int       `json:"maxUsesPerUser" db:"max_uses_per_user"`
+	Uses           int        `json:"uses" db:"uses"`
+	StartsAt       time.Time  `json:"startsAt" db:"starts_at"`
+	EndsAt         *time.Time `json:"endsAt" db:"ends_at"`
+	Active         bool       `json:"active" db:"active"`
+}
+
+func (p *Promo) appliesTo(item string) bool {
+	return p != nil && (p.Item == nil || *p.Item == item)
+}
+
+// price returns the discounted price of one unit; discounts never take a
+// price below one coin, since a purchase has to move some coins.
+func (p *Promo) price(item string, price int) int {
+	if !p.appliesTo(item) {
+		return price
+	}
+
+	discounted := price - p.Value
+	if p.Kind == PromoPercent {
+		discounted = price - price*p.Value/100
+	}
+	if discounted < 1 {
+		return 1
+	}
+	return discounted
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// lockPromo checks that userID may use the code right now and locks it, so
+// concurrent purchases cannot overrun the global limit.
+func lockPromo(tx *sqlx.Tx, userID int, code string) (*Promo, error) {
+	var promo Promo
+	err := tx.Get(&promo, "SELECT "+promoColumns+" FROM promo_codes WHERE code = $1 FOR UPDATE", code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errPromoInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case !promo.Active, now.Before(promo.StartsAt), promo.EndsAt != nil && !now.Before(*promo.EndsAt):
+		return nil, errPromoInvalid
+	case promo.MaxUses != nil && promo.Uses >= *promo.MaxUses:
+		return nil, errPromoExhausted
+	}
+
+	if promo.MaxUsesPerUser != nil {
+		var used int
+		err := tx.Get(&used, "SELECT COUNT(*) FROM promo_redemptions WHERE code = $1 AND user_id = $2", code, userID)
+		if err != nil {
+			return nil, err
+		}
+		if used >= *promo.MaxUsesPerUser {
+			return nil, errPromoUsed
+		}
+	}
+
+	return &promo, nil
+}
+
+// discountedTotal prices lines with the promo applied to every unit it
+// applies to; a nil promo leaves the prices as they are.
+func discountedTotal(lines []CartLine, promo *Promo) (total, discount int) {
+	for _, line := range lines {
+		price := promo.price(line.Item, line.UnitPrice)
+		total += price * line.Quantity
+		discount += (line.UnitPrice - price) * line.Quantity
+	}
+	return total, discount
+}
+
+// redeemPromo records one use of the code for the whole purchase.
+func redeemPromo(tx *sqlx.Tx, userID int, promo *Promo, discount int) error {
+	if discount <= 0 {
+		return errPromoNotApplicable
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO promo_redemptions (code, user_id, discount) VALUES ($1, $2, $3)`,
+		promo.Code, userID, discount)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE promo_codes SET uses = uses + 1 WHERE code = $1", promo.Code)
+	return err
+}
+
+func (h *StoreHandler) ListPromoCodes(c *gin.Context) {
+	promos := []Promo{}
+	err := h.db.DB.Select(&promos, "SELECT "+promoColumns+" FROM promo_codes ORDER BY created_at DESC")
+	if err != nil {
+		log.Printf("[ERR] failed to list promo codes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to list promo codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promoCodes": promos})
+}
+
+func (h *StoreHandler) CreatePromoCode(c *gin.Context) {
+	var req struct {
+		Code           string     `json:"code" binding:"required"`
+		Kind           string     `json:"kind" binding:"required,oneof=percent fixed"`
+		Value          int        `json:"value" binding:"required,min=1"`
+		Item           *string    `json:"item"`
+		MaxUses        *int       `json:"maxUses" binding:"omitempty,min=1"`
+		MaxUsesPerUser *int       `json:"maxUsesPerUser" binding:"omitempty,min=1"`
+		StartsAt       *time.Time `json:"startsAt"`
+		EndsAt         *time.Time `json:"endsAt"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	req.Code = normalizePromoCode(req.Code)
+	switch {
+	case !promoCodePattern.MatchString(req.Code),
+		req.Kind == PromoPercent && req.Value > 100,
+		req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	if req.Item != nil {
+		if _, ok := h.Catalog.Get(*req.Item); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Item not found"})
+			return
+		}
+	}
+
+	var promo Promo
+	err := h.db.DB.Get(&promo, `
+		INSERT INTO promo_codes (code, kind, value, item, max_uses, max_uses_per_user, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, now()), $8)
+		RETURNING `+promoColumns,
+		req.Code, req.Kind, req.Value, req.Item, req.MaxUses, req.MaxUsesPerUser, req.StartsAt, req.EndsAt)
+	if db.IsUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"errors": "Promo code already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to create promo code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to create promo code"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, promo)
+}
+
+// UpdatePromoCode switches a code on or off and moves its limits; the
+// discount itself is fixed once buyers may have seen it.
+func (h *StoreHandler) UpdatePromoCode(c *gin.Context) {
+	var req struct {
+		Active  *bool      `json:"active"`
+		MaxUses *int       `json:"maxUses" binding:"omitempty,min=1"`
+		EndsAt  *time.Time `json:"endsAt"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	var promo Promo
+	err := h.db.DB.Get(&promo, `
+		UPDATE promo_codes SET
+			active = COALESCE($1, active),
+			max_uses = COALESCE($2, max_uses),
+			ends_at = COALESCE($3, ends_at)
+		WHERE code = $4
+		RETURNING `+promoColumns,
+		req.Active, req.MaxUses, req.EndsAt, normalizePromoCode(c.Param("code")))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Promo code not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERR] failed to update promo code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to update promo code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, promo)
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var promoRowColumns = []string{"code", "kind", "value", "item", "max_uses", "max_uses_per_user", "uses", "starts_at", "ends_at", "active"}
+
+func TestPromoPrice(t *testing.T) {
+	tshirt := "t-shirt"
+
+	tests := []struct {
+		name     string
+		promo    *Promo
+		item     string
+		price    int
+		expected int
+	}{
+		{name: "No promo", promo: nil, item: "cup", price: 20, expected: 20},
+		{name: "Percentage", promo: &Promo{Kind: PromoPercent, Value: 10}, item: "cup", price: 80, expected: 72},
+		{name: "Percentage rounds in the buyer's favour", promo: &Promo{Kind: PromoPercent, Value: 15}, item: "cup", price: 20, expected: 17},
+		{name: "Fixed", promo: &Promo{Kind: PromoFixed, Value: 5}, item: "cup", price: 20, expected: 15},
+		{name: "Never below one coin", promo: &Promo{Kind: PromoFixed, Value: 30}, item: "cup", price: 20, expected: 1},
+		{name: "Other item", promo: &Promo{Kind: PromoPercent, Value: 50, Item: &tshirt}, item: "cup", price: 20, expected: 20},
+		{name: "Matching item", promo: &Promo{Kind: PromoPercent, Value: 50, Item: &tshirt}, item: "t-shirt", price: 80, expected: 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.promo.price(tt.item, tt.price))
+		})
+	}
+}
+
+func TestDiscountedTotal(t *testing.T) {
+	cup := "cup"
+	lines := []CartLine{
+		{Item: "cup", UnitPrice: 20, Quantity: 3},
+		{Item: "pen", UnitPrice: 4, Quantity: 2},
+	}
+
+	tests := []struct {
+		name             string
+		promo            *Promo
+		expectedTotal    int
+		expectedDiscount int
+	}{
+		{name: "No promo", promo: nil, expectedTotal: 68, expectedDiscount: 0},
+		// A fixed code comes off each unit: 3 cups and 2 pens, the pens
+		// floored at a coin.
+		{name: "Fixed, store-wide", promo: &Promo{Kind: PromoFixed, Value: 5}, expectedTotal: 47, expectedDiscount: 21},
+		{name: "Fixed, one item", promo: &Promo{Kind: PromoFixed, Value: 5, Item: &cup}, expectedTotal: 53, expectedDiscount: 15},
+		{name: "Percentage, store-wide", promo: &Promo{Kind: PromoPercent, Value: 50}, expectedTotal: 34, expectedDiscount: 34},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, discount := discountedTotal(lines, tt.promo)
+			assert.Equal(t, tt.expectedTotal, total)
+			assert.Equal(t, tt.expectedDiscount, discount)
+		})
+	}
+}
+
+func TestCreatePurchase_Promo(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT code, kind, value, item, max_uses, max_uses_per_user, uses, starts_at, ends_at, active FROM promo_codes WHERE code = \$1 FOR UPDATE`).
+		WithArgs("SPRING10").
+		WillReturnRows(sqlmock.NewRows(promoRowColumns).AddRow("SPRING10", PromoPercent, 10, nil, 100, 1, 3, now.Add(-time.Hour), nil, true))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM promo_redemptions WHERE code = \$1 AND user_id = \$2`).
+		WithArgs("SPRING10", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`INSERT INTO promo_redemptions \(code, user_id, discount\)`).
+		WithArgs("SPRING10", 1, 4).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE promo_codes SET uses = uses \+ 1 WHERE code = \$1`).
+		WithArgs("SPRING10").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id=\$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
+	mock.ExpectQuery(`SELECT stock FROM merch WHERE name = \$1 FOR UPDATE`).
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(nil))
+	expectLinePurchase(mock, 9, "cup", 36)
+	mock.ExpectQuery(`INSERT INTO orders`).
+		WithArgs(1, "cup", "", 18, 20, "SPRING10", 2, 9, nil, "").
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "list_price", "promo_code")).AddRow(4, "cup", 18, 2, "placed", now, now, 20, "SPRING10"))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodPost, "/api/purchases", `{"item": "cup", "quantity": 2, "promoCode": " spring10 "}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var res PurchaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 36, res.Total)
+	assert.Equal(t, 4, res.Discount)
+	assert.Equal(t, 64, res.Balance)
+	require.NotNil(t, res.Order.PromoCode)
+	assert.Equal(t, "SPRING10", *res.Order.PromoCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePurchase_PromoRejected(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		row          []driver.Value
+		usedByUser   int
+		expectedBody string
+	}{
+		{
+			name:         "Expired",
+			row:          []driver.Value{"SPRING10", PromoPercent, 10, nil, nil, nil, 0, now.Add(-2 * time.Hour), now.Add(-time.Hour), true},
+			expectedBody: `{"errors": "Invalid promo code"}`,
+		},
+		{
+			name:         "Global limit reached",
+			row:          []driver.Value{"SPRING10", PromoPercent, 10, nil, 5, nil, 5, now.Add(-time.Hour), nil, true},
+			expectedBody: `{"errors": "Promo code is used up"}`,
+		},
+		{
+			name:         "Already used by the buyer",
+			row:          []driver.Value{"SPRING10", PromoPercent, 10, nil, nil, 1, 2, now.Add(-time.Hour), nil, true},
+			usedByUser:   1,
+			expectedBody: `{"errors": "Promo code already used"}`,
+		},
+		{
+			name:         "Other item",
+			row:          []driver.Value{"SPRING10", PromoFixed, 10, "t-shirt", nil, nil, 0, now.Add(-time.Hour), nil, true},
+			expectedBody: `{"errors": "Promo code does not apply to this purchase"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`FROM promo_codes WHERE code = \$1 FOR UPDATE`).
+				WithArgs("SPRING10").
+				WillReturnRows(sqlmock.NewRows(promoRowColumns).AddRow(tt.row...))
+			if tt.usedByUser > 0 {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM promo_redemptions`).
+					WithArgs("SPRING10", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.usedByUser))
+			}
+			mock.ExpectRollback()
+
+			w := serve(r, http.MethodPost, "/api/purchases", `{"item": "cup", "promoCode": "SPRING10"}`)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreatePromoCode(t *testing.T) {
+	r, _, mock := setupAdminServer(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`INSERT INTO promo_codes \(code, kind, value, item, max_uses, max_uses_per_user, starts_at, ends_at\)`).
+		WithArgs("CUP5", PromoFixed, 5, "cup", 100, 1, nil, nil).
+		WillReturnRows(sqlmock.NewRows(promoRowColumns).AddRow("CUP5", PromoFixed, 5, "cup", 100, 1, 0, now, nil, true))
+
+	w := serve(r, http.MethodPost, "/api/admin/promo-codes",
+		`{"code": "cup5", "kind": "fixed", "value": 5, "item": "cup", "maxUses": 100, "maxUsesPerUser": 1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "Percentage over 100", body: `{"code": "HALF", "kind": "percent", "value": 150}`},
+		{name: "Unknown kind", body: `{"code": "HALF", "kind": "bogo", "value": 1}`},
+		{name: "Bad code", body: `{"code": "a b", "kind": "fixed", "value": 1}`},
+		{name: "Window ends before it starts", body: `{"code": "HALF", "kind": "fixed", "value": 1, "startsAt": "2025-03-02T00:00:00Z", "endsAt": "2025-03-01T00:00:00Z"}`},
+		{name: "Unknown item", body: `{"code": "HALF", "kind": "fixed", "value": 1, "item": "lamp"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/admin/promo-codes", tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
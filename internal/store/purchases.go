@@ -0,0 +1,162 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jamsi-max/merch-store/internal/memo"
+	"github.com/jmoiron/sqlx"
+)
+
+const purchasesEndpoint = "purchases"
+
+// PurchaseRequest.ExpectedPrice is the catalog unit price the client showed
+// the buyer, before any promo; the purchase is refused if it differs.
+type PurchaseRequest struct {
+	Item           string `json:"item" binding:"required"`
+	Variant        string `json:"variant"`
+	Quantity       int    `json:"quantity" binding:"omitempty,min=1,max=99"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	ExpectedPrice  *int   `json:"expectedPrice" binding:"omitempty,min=1"`
+	PromoCode      string `json:"promoCode,omitempty"`
+	GiftTo         string `json:"giftTo,omitempty"`
+	GiftNote       string `json:"giftNote,omitempty"`
+}
+
+type PurchaseResponse struct {
+	OrderID  int    `json:"orderId"`
+	Order    Order  `json:"order"`
+	Total    int    `json:"total"`
+	Discount int    `json:"discount,omitempty"`
+	Balance  int    `json:"balance"`
+	GiftTo   string `json:"giftTo,omitempty"`
+}
+
+func (h *StoreHandler) CreatePurchase(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req PurchaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	req.PromoCode = normalizePromoCode(req.PromoCode)
+
+	req.GiftNote = memo.Sanitize(req.GiftNote)
+	switch {
+	case req.GiftNote != "" && req.GiftTo == "":
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Gift note without a recipient"})
+		return
+	case utf8.RuneCountInString(req.GiftNote) > memo.MaxLength:
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Gift note is too long"})
+		return
+	}
+
+	// The key may come in the body or in the usual header, but not as two
+	// different keys.
+	headerKey, err := idempotency.KeyFromRequest(c)
+	if err != nil || idempotency.CheckKey(req.IdempotencyKey) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid Idempotency-Key"})
+		return
+	}
+	idempotencyKey := req.IdempotencyKey
+	switch {
+	case idempotencyKey == "":
+		idempotencyKey = headerKey
+	case headerKey != "" && headerKey != idempotencyKey:
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Conflicting Idempotency-Key"})
+		return
+	}
+
+	req.IdempotencyKey = ""
+	idempotent, done := h.idempotency.Begin(c, idempotencyKey, purchasesEndpoint, req)
+	if done {
+		return
+	}
+
+	line := CartLine{Item: req.Item, Variant: req.Variant, Quantity: req.Quantity}
+	line.UnitPrice, err = h.quote(line.Item, line.Variant)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": quoteMessages[err]})
+		return
+	}
+
+	if req.ExpectedPrice != nil && *req.ExpectedPrice != line.UnitPrice {
+		c.JSON(http.StatusConflict, gin.H{"errors": "Price changed", "price": line.UnitPrice})
+		return
+	}
+
+	var recipient *gift
+	if req.GiftTo != "" {
+		recipient = &gift{note: req.GiftNote}
+		err := h.db.DB.Get(&recipient.recipientID, "SELECT id FROM users WHERE name = $1", req.GiftTo)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Recipient not found"})
+			return
+		}
+		if recipient.recipientID == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Cannot gift to yourself"})
+			return
+		}
+	}
+
+	var body []byte
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		if err := idempotent.Claim(tx); err != nil {
+			return err
+		}
+
+		promo, total, err := applyPromo(tx, userID, req.PromoCode, []CartLine{line})
+		if err != nil {
+			return err
+		}
+
+		coins, err := lockBalance(tx, userID, total)
+		if err != nil {
+			return err
+		}
+
+		order, err := placeOrder(tx, userID, line, promo, recipient)
+		if err != nil {
+			return err
+		}
+
+		body, err = json.Marshal(PurchaseResponse{
+			OrderID:  order.ID,
+			Order:    order,
+			Total:    total,
+			Discount: line.UnitPrice*line.Quantity - total,
+			Balance:  coins - total,
+			GiftTo:   req.GiftTo,
+		})
+		if err != nil {
+			return err
+		}
+
+		return idempotent.Save(tx, http.StatusCreated, body)
+	})
+
+	switch {
+	case err == nil:
+		c.Data(http.StatusCreated, "application/json; charset=utf-8", body)
+	case errors.Is(err, errInsufficientFunds), db.IsCheckViolation(err):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Insufficient funds"})
+	case errors.Is(err, errOutOfStock):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Out of stock"})
+	case promoMessages[err] != "":
+		c.JSON(http.StatusBadRequest, gin.H{"errors": promoMessages[err]})
+	case idempotent.Finish(c, err):
+	default:
+		log.Printf("[ERR] purchase failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Purchase failed"})
+	}
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jamsi-max/merch-store/internal/memo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePurchase(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	expectPurchase(mock, 100)
+	mock.ExpectQuery(`SELECT stock FROM merch_variants WHERE sku = \$1 FOR UPDATE`).
+		WithArgs("t-shirt-m").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(nil))
+	expectLinePurchase(mock, 9, "t-shirt/t-shirt-m", 80)
+	mock.ExpectQuery(`INSERT INTO orders`).
+		WithArgs(1, "t-shirt", "t-shirt-m", 80, 80, "", 1, 9, nil, "").
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "variant")).AddRow(4, "t-shirt", 80, 1, "placed", now, now, "t-shirt-m"))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodPost, "/api/purchases", `{"item": "t-shirt", "variant": "t-shirt-m", "expectedPrice": 80}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var res PurchaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 4, res.OrderID)
+	assert.Equal(t, 80, res.Total)
+	assert.Equal(t, 20, res.Balance)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePurchase_Quantity(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	expectPurchase(mock, 50)
+	mock.ExpectRollback()
+
+	w := serve(r, http.MethodPost, "/api/purchases", `{"item": "cup", "quantity": 3}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Insufficient funds"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePurchase_Rejected(t *testing.T) {
+	r, _ := setupOrdersServer(t)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Missing item", body: `{"quantity": 1}`, expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Invalid request"}`},
+		{name: "Too many", body: `{"item": "cup", "quantity": 100}`, expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Invalid request"}`},
+		{name: "Retired item", body: `{"item": "umbrella"}`, expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Item not found"}`},
+		{name: "Price changed", body: `{"item": "cup", "expectedPrice": 15}`, expectedStatus: http.StatusConflict, expectedBody: `{"errors": "Price changed", "price": 20}`},
+		{name: "Invalid key", body: `{"item": "cup", "idempotencyKey": "not a key"}`, expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Invalid Idempotency-Key"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/purchases", tt.body)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/purchases", bytes.NewBufferString(`{"item": "cup", "idempotencyKey": "key-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotency.Header, "key-2")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Conflicting Idempotency-Key"}`, w.Body.String())
+}
+
+func TestCreatePurchase_Replay(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	requestHash := idempotency.Hash(purchasesEndpoint, PurchaseRequest{Item: "cup", Quantity: 1})
+	mock.ExpectQuery(`SELECT request_hash, status_code, response_body FROM idempotency_keys`).
+		WithArgs(1, "key-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "response_body"}).
+			AddRow(requestHash, http.StatusCreated, []byte(`{"orderId": 4}`)))
+
+	w := serve(r, http.MethodPost, "/api/purchases", `{"item": "cup", "idempotencyKey": "key-1"}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"orderId": 4}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePurchase_Gift(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	mock.ExpectQuery(`SELECT id FROM users WHERE name = \$1`).
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	expectPurchase(mock, 100)
+	mock.ExpectQuery(`SELECT stock FROM merch WHERE name = \$1 FOR UPDATE`).
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(nil))
+	expectLinePurchase(mock, 9, "cup", 20)
+	mock.ExpectQuery(`INSERT INTO orders`).
+		WithArgs(1, "cup", "", 20, 20, "", 1, 9, 2, "Happy birthday!").
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "recipient_id", "gift_note")).
+			AddRow(4, "cup", 20, 1, "placed", now, now, 2, "Happy birthday!"))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodPost, "/api/purchases", `{"item": "cup", "giftTo": "bob", "giftNote": "  Happy birthday!  "}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var res PurchaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "bob", res.GiftTo)
+	require.NotNil(t, res.Order.RecipientID)
+	assert.Equal(t, 2, *res.Order.RecipientID)
+	assert.Equal(t, "Happy birthday!", res.Order.GiftNote)
+	assert.Equal(t, 80, res.Balance)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePurchase_GiftRejected(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Note without recipient", body: `{"item": "cup", "giftNote": "hi"}`, expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Gift note without a recipient"}`},
+		{name: "Note too long", body: `{"item": "cup", "giftTo": "bob", "giftNote": "` + strings.Repeat("a", memo.MaxLength+1) + `"}`, expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Gift note is too long"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/purchases", tt.body)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+
+	mock.ExpectQuery(`SELECT id FROM users WHERE name = \$1`).
+		WithArgs("nobody").
+		WillReturnError(sql.ErrNoRows)
+
+	w := serve(r, http.MethodPost, "/api/purchases", `{"item": "cup", "giftTo": "nobody"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Recipient not found"}`, w.Body.String())
+
+	mock.ExpectQuery(`SELECT id FROM users WHERE name = \$1`).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	w = serve(r, http.MethodPost, "/api/purchases", `{"item": "cup", "giftTo": "alice"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Cannot gift to yourself"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/memo"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+)
+
+const returnColumns = "id, order_id, quantity, reason, status, refund_amount, created_at, decided_at"
+
+var (
+	errReturnNotFound     = errors.New("return not found")
+	errReturnDecided      = errors.New("return already decided")
+	errOrderNotReturnable = errors.New("order is not returnable")
+	errReturnTooMany      = errors.New("return quantity exceeds the order")
+	errInvalidRefund      = errors.New("invalid refund amount")
+)
+
+type Return struct {
+	ID           int        `json:"id" db:"id"`
+	OrderID      int        `json:"orderId" db:"order_id"`
+	Quantity     int        `json:"quantity" db:"quantity"`
+	Reason       string     `json:"reason,omitempty" db:"reason"`
+	Status       string     `json:"status" db:"status"`
+	RefundAmount *int       `json:"refundAmount,omitempty" db:"refund_amount"`
+	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
+	DecidedAt    *time.Time `json:"decidedAt,omitempty" db:"decided_at"`
+}
+
+type returnedOrder struct {
+	paidOrder
+	ReturnedQuantity int `db:"returned_quantity"`
+}
+
+// RequestReturn asks for some or all items of a delivered order to be taken
+// back. Nothing moves until an admin approves it.
+func (h *StoreHandler) RequestReturn(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || orderID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid order id"})
+		return
+	}
+
+	var req struct {
+		Quantity int    `json:"quantity" binding:"omitempty,min=1,max=99"`
+		Reason   string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	req.Reason = memo.Sanitize(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > memo.MaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Reason is too long"})
+		return
+	}
+
+	var ret Return
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		order, err := lockReturnedOrder(tx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.UserID != userID {
+			return errOrderNotFound
+		}
+		if order.Status != OrderDelivered {
+			return errOrderNotReturnable
+		}
+
+		var pending int
+		err = tx.Get(&pending, `
+			SELECT COALESCE(SUM(quantity), 0) FROM order_returns WHERE order_id = $1 AND status = $2`,
+			orderID, ReturnRequested)
+		if err != nil {
+			return err
+		}
+		if req.Quantity > order.Quantity-order.ReturnedQuantity-pending {
+			return errReturnTooMany
+		}
+
+		return tx.Get(&ret, `
+			INSERT INTO order_returns (order_id, quantity, reason) VALUES ($1, $2, $3)
+			RETURNING `+returnColumns,
+			orderID, req.Quantity, req.Reason)
+	})
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, ret)
+	case errors.Is(err, errOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Order not found"})
+	case errors.Is(err, errOrderNotReturnable):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Only delivered orders can be returned"})
+	case errors.Is(err, errReturnTooMany):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Return quantity exceeds the order"})
+	default:
+		log.Printf("[ERR] failed to request return: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to request return"})
+	}
+}
+
+type DeskReturn struct {
+	Return
+	User string `json:"user" db:"user_name"`
+	Item string `json:"item" db:"item"`
+}
+
+func (h *StoreHandler) ListReturns(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", ReturnRequested, ReturnApproved, ReturnRejected:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid status"})
+		return
+	}
+
+	returns := []DeskReturn{}
+	err := h.db.DB.Select(&returns, `
+		SELECT r.id, r.order_id, r.quantity, r.reason, r.status, r.refund_amount, r.created_at, r.decided_at,
+			u.name AS user_name, o.item
+		FROM order_returns r
+		JOIN orders o ON o.id = r.order_id
+		JOIN users u ON u.id = o.user_id
+		WHERE $1 = '' OR r.status = $1
+		ORDER BY r.id DESC
+		LIMIT $2`,
+		status, maxOrdersPageSize)
+	if err != nil {
+		log.Printf("[ERR] failed to list returns: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to list returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"returns": returns})
+}
+
+// DecideReturn approves or rejects a return request. An approved return takes
+// the items out of the holder's inventory, puts them back on the shelf and
+// refunds the buyer the paid price, or the smaller refundAmount if given.
+func (h *StoreHandler) DecideReturn(c *gin.Context) {
+	returnID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || returnID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid return id"})
+		return
+	}
+
+	var req struct {
+		Status       string `json:"status" binding:"required,oneof=approved rejected"`
+		RefundAmount *int   `json:"refundAmount" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Status == ReturnRejected && req.RefundAmount != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	var ret Return
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		err := tx.Get(&ret, `SELECT `+returnColumns+` FROM order_returns WHERE id = $1 FOR UPDATE`, returnID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errReturnNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if ret.Status != ReturnRequested {
+			return errReturnDecided
+		}
+
+		var refund *int
+		if req.Status == ReturnApproved {
+			order, err := lockReturnedOrder(tx, ret.OrderID)
+			if err != nil {
+				return err
+			}
+
+			amount := order.UnitPrice * ret.Quantity
+			if req.RefundAmount != nil {
+				if *req.RefundAmount > amount {
+					return errInvalidRefund
+				}
+				amount = *req.RefundAmount
+			}
+			refund = &amount
+
+			if err := returnItems(tx, order, ret, amount); err != nil {
+				return err
+			}
+		}
+
+		return tx.Get(&ret, `
+			UPDATE order_returns SET status = $1, refund_amount = $2, decided_by = $3, decided_at = now()
+			WHERE id = $4
+			RETURNING `+returnColumns,
+			req.Status, refund, c.GetInt("userID"), returnID)
+	})
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, ret)
+	case errors.Is(err, errReturnNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"errors": "Return not found"})
+	case errors.Is(err, errReturnDecided):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Return is already " + ret.Status})
+	case errors.Is(err, errInvalidRefund):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Refund cannot exceed the paid price"})
+	case errors.Is(err, errNotEnoughItems):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Order items were handed over to another user"})
+	case errors.Is(err, errOrderNotRefundable):
+		c.JSON(http.StatusConflict, gin.H{"errors": "Order predates the ledger and cannot be refunded"})
+	default:
+		log.Printf("[ERR] failed to decide return: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to decide return"})
+	}
+}
+
+func lockReturnedOrder(tx *sqlx.Tx, orderID int) (returnedOrder, error) {
+	var order returnedOrder
+	err := tx.Get(&order, `
+		SELECT `+paidOrderColumns+`, returned_quantity FROM orders WHERE id = $1 FOR UPDATE`, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return order, errOrderNotFound
+	}
+	return order, err
+}
+
+// returnItems moves the returned items from the holder back to the shelf and
+// pays the refund to the buyer, recording it in their coin history.
+func returnItems(tx *sqlx.Tx, order returnedOrder, ret Return, refund int) error {
+	if err := lockOrderItems(tx, order.UserID, order.Order, ret.Quantity); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+		UPDATE orders SET returned_quantity = returned_quantity + $1, updated_at = now() WHERE id = $2`,
+		ret.Quantity, order.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := restock(tx, order.Order, ret.Quantity); err != nil {
+		return err
+	}
+
+	return refundOrder(tx, order.paidOrder, refund, "return:"+strconv.Itoa(ret.ID), "Return of "+order.Item)
+}
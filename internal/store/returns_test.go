@@ -0,0 +1,152 @@
+package store
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var returnRowColumns = []string{"id", "order_id", "quantity", "reason", "status", "refund_amount", "created_at"}
+
+func expectLockReturnedOrder(mock sqlmock.Sqlmock, userID int, status string, quantity, returned int) {
+	now := time.Now()
+	mock.ExpectQuery(`SELECT id, item, .*, user_id, ledger_txn_id, returned_quantity FROM orders WHERE id = \$1 FOR UPDATE`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(append(orderRowColumns, "user_id", "ledger_txn_id", "returned_quantity")).
+			AddRow(3, "cup", 20, quantity, status, now, now, userID, 7, returned))
+}
+
+func TestRequestReturn(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	expectLockReturnedOrder(mock, 1, OrderDelivered, 3, 1)
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(quantity\), 0\) FROM order_returns WHERE order_id = \$1 AND status = \$2`).
+		WithArgs(3, ReturnRequested).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectQuery(`INSERT INTO order_returns \(order_id, quantity, reason\)`).
+		WithArgs(3, 2, "Wrong size").
+		WillReturnRows(sqlmock.NewRows(returnRowColumns).AddRow(4, 3, 2, "Wrong size", ReturnRequested, nil, now))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodPost, "/api/orders/3/returns", `{"quantity": 2, "reason": " Wrong size "}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"requested"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequestReturn_Rejected(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	tests := []struct {
+		name           string
+		userID         int
+		status         string
+		pending        int
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Someone else's order", userID: 2, status: OrderDelivered, expectedStatus: http.StatusNotFound, expectedBody: `{"errors": "Order not found"}`},
+		{name: "Not delivered yet", userID: 1, status: OrderApproved, expectedStatus: http.StatusConflict, expectedBody: `{"errors": "Only delivered orders can be returned"}`},
+		{name: "Already being returned", userID: 1, status: OrderDelivered, pending: 1, expectedStatus: http.StatusBadRequest, expectedBody: `{"errors": "Return quantity exceeds the order"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			expectLockReturnedOrder(mock, tt.userID, tt.status, 1, 0)
+			if tt.pending > 0 {
+				mock.ExpectQuery(`FROM order_returns WHERE order_id = \$1`).
+					WithArgs(3, ReturnRequested).
+					WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(tt.pending))
+			}
+			mock.ExpectRollback()
+
+			w := serve(r, http.MethodPost, "/api/orders/3/returns", `{}`)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDecideReturn_Approve(t *testing.T) {
+	r, mock := setupDeskServer(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM order_returns WHERE id = \$1 FOR UPDATE`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(returnRowColumns).AddRow(4, 3, 1, "Broken", ReturnRequested, nil, now))
+	expectLockReturnedOrder(mock, 1, OrderDelivered, 2, 0)
+	expectHolding(mock, 1, "cup", "", 2)
+	mock.ExpectExec(`UPDATE orders SET returned_quantity = returned_quantity \+ \$1`).
+		WithArgs(1, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE merch SET stock = stock \+ \$1 WHERE name = \$2 AND stock IS NOT NULL`).
+		WithArgs(1, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO ledger_txns`).
+		WithArgs("refund", "return:4").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(12, "system:store", -15).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO ledger_accounts \(code, user_id\)`).
+		WithArgs("user:1", 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO ledger_entries`).
+		WithArgs(12, "user:1", 15).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(15, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO transactions \(receiver_id, amount, message, kind\) VALUES \(\$1, \$2, \$3, 'refund'\)`).
+		WithArgs(1, 15, "Return of cup").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`UPDATE order_returns SET status = \$1, refund_amount = \$2, decided_by = \$3, decided_at = now\(\)`).
+		WithArgs(ReturnApproved, 15, 99, 4).
+		WillReturnRows(sqlmock.NewRows(returnRowColumns).AddRow(4, 3, 1, "Broken", ReturnApproved, 15, now))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodPatch, "/api/admin/returns/4", `{"status": "approved", "refundAmount": 15}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"refundAmount":15`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDecideReturn_Guarded(t *testing.T) {
+	r, mock := setupDeskServer(t)
+	now := time.Now()
+
+	w := serve(r, http.MethodPatch, "/api/admin/returns/4", `{"status": "rejected", "refundAmount": 5}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM order_returns WHERE id = \$1 FOR UPDATE`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(returnRowColumns).AddRow(4, 3, 1, "", ReturnRejected, nil, now))
+	mock.ExpectRollback()
+
+	w = serve(r, http.MethodPatch, "/api/admin/returns/4", `{"status": "approved"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors": "Return is already rejected"}`, w.Body.String())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM order_returns WHERE id = \$1 FOR UPDATE`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(returnRowColumns).AddRow(4, 3, 1, "", ReturnRequested, nil, now))
+	expectLockReturnedOrder(mock, 1, OrderDelivered, 1, 0)
+	mock.ExpectRollback()
+
+	w = serve(r, http.MethodPatch, "/api/admin/returns/4", `{"status": "approved", "refundAmount": 25}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Refund cannot exceed the paid price"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/idempotency"
+	"github.com/jmoiron/sqlx"
+)
+
+const itemTransfersEndpoint = "itemTransfers"
+
+var errNotEnoughItems = errors.New("not enough items")
+
+type ItemTransferRequest struct {
+	ToUser   string `json:"toUser" binding:"required"`
+	Item     string `json:"item" binding:"required"`
+	Variant  string `json:"variant"`
+	Quantity int    `json:"quantity" binding:"omitempty,min=1,max=99"`
+}
+
+type ItemTransfer struct {
+	ID        int       `json:"id" db:"id"`
+	ToUser    string    `json:"toUser" db:"to_user"`
+	Item      string    `json:"item" db:"item"`
+	Variant   string    `json:"variant,omitempty" db:"variant"`
+	Quantity  int       `json:"quantity" db:"quantity"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// TransferItems hands owned merch over to another user. Nothing is bought or
+// refunded; only the inventory of both users changes.
+func (h *StoreHandler) TransferItems(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req ItemTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	idempotencyKey, err := idempotency.KeyFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid Idempotency-Key"})
+		return
+	}
+	idempotent, done := h.idempotency.Begin(c, idempotencyKey, itemTransfersEndpoint, req)
+	if done {
+		return
+	}
+
+	var toUserID int
+	err = h.db.DB.Get(&toUserID, "SELECT id FROM users WHERE name = $1", req.ToUser)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Recipient not found"})
+		return
+	}
+	if toUserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Cannot transfer to yourself"})
+		return
+	}
+
+	var body []byte
+	err = h.db.WithTx(c.Request.Context(), func(tx *sqlx.Tx) error {
+		if err := idempotent.Claim(tx); err != nil {
+			return err
+		}
+
+		if err := lockHolding(tx, userID, req.Item, req.Variant, req.Quantity); err != nil {
+			return err
+		}
+
+		var transfer ItemTransfer
+		err := tx.Get(&transfer, `
+			INSERT INTO item_transfers (sender_id, receiver_id, item, variant, quantity)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, item, variant, quantity, created_at`,
+			userID, toUserID, req.Item, req.Variant, req.Quantity)
+		if err != nil {
+			return err
+		}
+		transfer.ToUser = req.ToUser
+
+		if body, err = json.Marshal(transfer); err != nil {
+			return err
+		}
+
+		return idempotent.Save(tx, http.StatusCreated, body)
+	})
+
+	switch {
+	case err == nil:
+		c.Data(http.StatusCreated, "application/json; charset=utf-8", body)
+	case errors.Is(err, errNotEnoughItems):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Not enough items"})
+	case idempotent.Finish(c, err):
+	default:
+		log.Printf("[ERR] item transfer failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Item transfer failed"})
+	}
+}
+
+// lockHolding checks that the user holds at least quantity of an item. The
+// inventory is derived from orders and transfers, so there is no row to lock
+// for it; the user row is locked instead, as cancelling an order the user
+// holds does too.
+func lockHolding(tx *sqlx.Tx, userID int, item, sku string, quantity int) error {
+	if _, err := tx.Exec("SELECT id FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return err
+	}
+
+	var held int
+	err := tx.Get(&held, `
+		SELECT COALESCE(SUM(quantity), 0) FROM user_inventory
+		WHERE user_id = $1 AND item = $2 AND variant = $3`,
+		userID, item, sku)
+	if err != nil {
+		return err
+	}
+	if held < quantity {
+		return errNotEnoughItems
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectRecipient(mock sqlmock.Sqlmock, name string, id int) {
+	mock.ExpectQuery(`SELECT id FROM users WHERE name = \$1`).
+		WithArgs(name).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id))
+}
+
+func TestTransferItems(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	expectRecipient(mock, "bob", 2)
+	mock.ExpectBegin()
+	expectHolding(mock, 1, "t-shirt", "t-shirt-m", 3)
+	mock.ExpectQuery(`INSERT INTO item_transfers \(sender_id, receiver_id, item, variant, quantity\)`).
+		WithArgs(1, 2, "t-shirt", "t-shirt-m", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item", "variant", "quantity", "created_at"}).
+			AddRow(5, "t-shirt", "t-shirt-m", 2, now))
+	mock.ExpectCommit()
+
+	w := serve(r, http.MethodPost, "/api/item-transfers", `{"toUser": "bob", "item": "t-shirt", "variant": "t-shirt-m", "quantity": 2}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var transfer ItemTransfer
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transfer))
+	assert.Equal(t, ItemTransfer{ID: 5, ToUser: "bob", Item: "t-shirt", Variant: "t-shirt-m", Quantity: 2, CreatedAt: now}, transfer)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransferItems_Rejected(t *testing.T) {
+	r, mock := setupOrdersServer(t)
+
+	w := serve(r, http.MethodPost, "/api/item-transfers", `{"toUser": "bob", "item": "cup", "quantity": 100}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Invalid request"}`, w.Body.String())
+
+	mock.ExpectQuery(`SELECT id FROM users WHERE name = \$1`).
+		WithArgs("nobody").
+		WillReturnError(sql.ErrNoRows)
+
+	w = serve(r, http.MethodPost, "/api/item-transfers", `{"toUser": "nobody", "item": "cup"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Recipient not found"}`, w.Body.String())
+
+	expectRecipient(mock, "alice", 1)
+
+	w = serve(r, http.MethodPost, "/api/item-transfers", `{"toUser": "alice", "item": "cup"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Cannot transfer to yourself"}`, w.Body.String())
+
+	expectRecipient(mock, "bob", 2)
+	mock.ExpectBegin()
+	expectHolding(mock, 1, "cup", "", 1)
+	mock.ExpectRollback()
+
+	w = serve(r, http.MethodPost, "/api/item-transfers", `{"toUser": "bob", "item": "cup", "quantity": 2}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors": "Not enough items"}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
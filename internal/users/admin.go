@@ -0,0 +1,67 @@
+package users
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/auth"
+)
+
+func (u *UserHandler) SetUserRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	name := c.Param("name")
+	if name == c.GetString("username") && req.Role != auth.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Admins cannot revoke their own role"})
+		return
+	}
+
+	res, err := u.db.DB.Exec("UPDATE users SET role = $1 WHERE name = $2", req.Role, name)
+	if err != nil {
+		log.Printf("[ERR] failed to update role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to update role"})
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"name": name, "role": req.Role})
+}
+
+// SetUserActive marks whether a user still receives the monthly allowance.
+func (u *UserHandler) SetUserActive(c *gin.Context) {
+	var req struct {
+		Active *bool `json:"active" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid request"})
+		return
+	}
+
+	name := c.Param("name")
+	res, err := u.db.DB.Exec("UPDATE users SET active = $1 WHERE name = $2", *req.Active, name)
+	if err != nil {
+		log.Printf("[ERR] failed to update active flag: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to update user"})
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"errors": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"name": name, "active": *req.Active})
+}
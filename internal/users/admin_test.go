@@ -0,0 +1,130 @@
+package users
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetUserRole(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	userHandler := NewUserHandler(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")})
+	r.PUT("/api/admin/users/:name/role", func(c *gin.Context) {
+		c.Set("username", "boss")
+		userHandler.SetUserRole(c)
+	})
+
+	tests := []struct {
+		name           string
+		user           string
+		body           string
+		setupMock      func()
+		expectedStatus int
+	}{
+		{
+			name: "Promote to manager",
+			user: "alice",
+			body: `{"role": "manager"}`,
+			setupMock: func() {
+				mock.ExpectExec(`UPDATE users SET role = \$1 WHERE name = \$2`).
+					WithArgs("manager", "alice").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Unknown user",
+			user: "ghost",
+			body: `{"role": "admin"}`,
+			setupMock: func() {
+				mock.ExpectExec(`UPDATE users SET role = \$1 WHERE name = \$2`).
+					WithArgs("admin", "ghost").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Unknown role",
+			user:           "alice",
+			body:           `{"role": "superuser"}`,
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Admin demotes themselves",
+			user:           "boss",
+			body:           `{"role": "employee"}`,
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock()
+
+			req, err := http.NewRequest(http.MethodPut, "/api/admin/users/"+tt.user+"/role", bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSetUserActive(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+
+	userHandler := NewUserHandler(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")})
+	r.PUT("/api/admin/users/:name/active", userHandler.SetUserActive)
+
+	put := func(user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/users/"+user+"/active", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	mock.ExpectExec(`UPDATE users SET active = \$1 WHERE name = \$2`).
+		WithArgs(false, "alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := put("alice", `{"active": false}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name": "alice", "active": false}`, w.Body.String())
+
+	mock.ExpectExec(`UPDATE users SET active = \$1 WHERE name = \$2`).
+		WithArgs(true, "ghost").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w = put("ghost", `{"active": true}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = put("alice", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,31 +1,37 @@
 package users
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/auth"
 	"github.com/jamsi-max/merch-store/internal/db"
 	"github.com/jamsi-max/merch-store/utils"
 )
 
+// recentHistoryLimit bounds each /info history list; the full history is
+// paginated through /api/transactions.
+const recentHistoryLimit = 50
+
+// counterpartyName is the other side of a coin history row joined as u.
+// Grants, deductions and refunds come from or go to the system, not a user.
+const counterpartyName = "CASE WHEN t.kind = 'transfer' THEN COALESCE(u.name, '') ELSE '" + auth.SystemName + "' END"
+
 type UserHandler struct {
-	db   *db.Database
-	Info InfoResponse
+	db *db.Database
 }
 
 func NewUserHandler(db *db.Database) *UserHandler {
-	info := InfoResponse{
-		Inventory: []InventoryItem{},
-		CoinHistory: CoinHistory{
-			Received: []CoinTransaction{},
-			Sent:     []CoinTransaction{},
-		},
-	}
-
-	return &UserHandler{db: db, Info: info}
+	return &UserHandler{db: db}
 }
 
+// infoTxOptions gives the /info queries one snapshot, so the balance
+// always matches the inventory and history returned next to it.
+var infoTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
 func (u *UserHandler) GetUserInfo(c *gin.Context) {
 	userID, ok := c.Get("userID")
 	if !ok {
@@ -33,41 +39,135 @@ func (u *UserHandler) GetUserInfo(c *gin.Context) {
 		return
 	}
 
-	err := u.db.DB.Get(&u.Info.Coins, "SELECT coins FROM users WHERE id=$1", userID)
+	tx, err := u.db.DB.BeginTxx(c.Request.Context(), infoTxOptions)
+	if err != nil {
+		log.Printf("[ERR] failed to begin transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get balance"})
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("[ERR] failed to rollback transaction: %v", err)
+		}
+	}()
+
+	info := InfoResponse{
+		Inventory: []InventoryItem{},
+		CoinHistory: CoinHistory{
+			Received: []CoinTransaction{},
+			Sent:     []CoinTransaction{},
+		},
+		GiftHistory: GiftHistory{
+			Received: []Gift{},
+			Sent:     []Gift{},
+		},
+		ItemHistory: ItemHistory{
+			Received: []ItemTransfer{},
+			Sent:     []ItemTransfer{},
+		},
+	}
+
+	err = tx.Get(&info.Coins, "SELECT coins FROM users WHERE id=$1", userID)
 	if err != nil {
 		log.Printf("[ERR] failed to get balance: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get balance"})
 		return
 	}
 
-	err = u.db.DB.Select(&u.Info.Inventory, "SELECT item, quantity FROM user_merch WHERE user_id=$1", userID)
+	err = tx.Select(&info.Inventory, `
+		SELECT item, variant, quantity
+		FROM user_inventory
+		WHERE user_id = $1
+		ORDER BY item, variant`, userID)
 	if err != nil {
-		log.Printf("[ERR] failed to get user_merch: %v", err)
+		log.Printf("[ERR] failed to get inventory: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get user merch"})
 		return
 	}
 
-	err = u.db.DB.Select(&u.Info.CoinHistory.Received, `
-		SELECT u.name AS sender_id, t.amount 
-		FROM transactions t 
-		JOIN users u ON t.sender_id = u.id 
-		WHERE t.receiver_id = $1`, userID)
+	err = tx.Select(&info.CoinHistory.Received, `
+		SELECT t.id, `+counterpartyName+` AS sender_id, t.amount, t.message, t.tag, t.kind, t.created_at
+		FROM transactions t
+		LEFT JOIN users u ON t.sender_id = u.id
+		WHERE t.receiver_id = $1
+		ORDER BY t.id DESC
+		LIMIT $2`, userID, recentHistoryLimit)
 	if err != nil {
 		log.Printf("[ERR] failed to get received transactions: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get received transactions"})
 		return
 	}
 
-	err = u.db.DB.Select(&u.Info.CoinHistory.Sent, `
-		SELECT u.name AS receiver_id, t.amount 
-		FROM transactions t 
-		JOIN users u ON t.receiver_id = u.id 
-		WHERE t.sender_id = $1`, userID)
+	err = tx.Select(&info.CoinHistory.Sent, `
+		SELECT t.id, `+counterpartyName+` AS receiver_id, t.amount, t.message, t.tag, t.kind, t.created_at
+		FROM transactions t
+		LEFT JOIN users u ON t.receiver_id = u.id
+		WHERE t.sender_id = $1
+		ORDER BY t.id DESC
+		LIMIT $2`, userID, recentHistoryLimit)
 	if err != nil {
 		log.Printf("[ERR] failed to get sent transactions: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get sent transactions"})
 		return
 	}
 
-	c.JSON(http.StatusOK, u.Info)
+	err = tx.Select(&info.GiftHistory.Received, `
+		SELECT o.id, u.name AS from_user, o.item, COALESCE(o.variant, '') AS variant, o.quantity, o.gift_note, o.created_at
+		FROM orders o
+		JOIN users u ON o.user_id = u.id
+		WHERE o.recipient_id = $1 AND o.status <> 'cancelled'
+		ORDER BY o.id DESC
+		LIMIT $2`, userID, recentHistoryLimit)
+	if err != nil {
+		log.Printf("[ERR] failed to get received gifts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get received gifts"})
+		return
+	}
+
+	err = tx.Select(&info.GiftHistory.Sent, `
+		SELECT o.id, u.name AS to_user, o.item, COALESCE(o.variant, '') AS variant, o.quantity, o.gift_note, o.created_at
+		FROM orders o
+		JOIN users u ON o.recipient_id = u.id
+		WHERE o.user_id = $1 AND o.status <> 'cancelled'
+		ORDER BY o.id DESC
+		LIMIT $2`, userID, recentHistoryLimit)
+	if err != nil {
+		log.Printf("[ERR] failed to get sent gifts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get sent gifts"})
+		return
+	}
+
+	err = tx.Select(&info.ItemHistory.Received, `
+		SELECT t.id, u.name AS from_user, t.item, t.variant, t.quantity, t.created_at
+		FROM item_transfers t
+		JOIN users u ON t.sender_id = u.id
+		WHERE t.receiver_id = $1
+		ORDER BY t.id DESC
+		LIMIT $2`, userID, recentHistoryLimit)
+	if err != nil {
+		log.Printf("[ERR] failed to get received items: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get received items"})
+		return
+	}
+
+	err = tx.Select(&info.ItemHistory.Sent, `
+		SELECT t.id, u.name AS to_user, t.item, t.variant, t.quantity, t.created_at
+		FROM item_transfers t
+		JOIN users u ON t.receiver_id = u.id
+		WHERE t.sender_id = $1
+		ORDER BY t.id DESC
+		LIMIT $2`, userID, recentHistoryLimit)
+	if err != nil {
+		log.Printf("[ERR] failed to get sent items: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get sent items"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("[ERR] failed to commit info transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to get balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
 }
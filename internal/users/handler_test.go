@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -36,22 +38,42 @@ func TestGetUserInfo(t *testing.T) {
 	require.NoError(t, err)
 	defer mockDB.Close()
 
+	mock.ExpectBegin()
+
 	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1").
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(1000))
 
-	mock.ExpectQuery("SELECT item, quantity FROM user_merch WHERE user_id=\\$1").
+	mock.ExpectQuery("SELECT item, variant, quantity FROM user_inventory WHERE user_id = \\$1").
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"item", "quantity"}))
 
-	mock.ExpectQuery("SELECT u.name AS sender_id, t.amount FROM transactions t JOIN users u ON t.sender_id = u.id WHERE t.receiver_id = \\$1").
-		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"sender_id", "amount"}))
+	mock.ExpectQuery("SELECT t.id, .* AS sender_id, t.amount, t.message, t.tag, t.kind, t.created_at FROM transactions t LEFT JOIN users u ON t.sender_id = u.id WHERE t.receiver_id = \\$1").
+		WithArgs(1, recentHistoryLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender_id", "amount", "message", "kind"}).AddRow(12, "system", 40, "Return of cup", "refund"))
 
-	mock.ExpectQuery("SELECT u.name AS receiver_id, t.amount FROM transactions t JOIN users u ON t.receiver_id = u.id WHERE t.sender_id = \\$1").
-		WithArgs(1).
+	mock.ExpectQuery("SELECT t.id, .* AS receiver_id, t.amount, t.message, t.tag, t.kind, t.created_at FROM transactions t LEFT JOIN users u ON t.receiver_id = u.id WHERE t.sender_id = \\$1").
+		WithArgs(1, recentHistoryLimit).
 		WillReturnRows(sqlmock.NewRows([]string{"receiver_id", "amount"}))
 
+	mock.ExpectQuery("SELECT o.id, u.name AS from_user, .* FROM orders o JOIN users u ON o.user_id = u.id WHERE o.recipient_id = \\$1").
+		WithArgs(1, recentHistoryLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_user", "item", "quantity", "gift_note"}).AddRow(7, "bob", "cup", 1, "Happy birthday!"))
+
+	mock.ExpectQuery("SELECT o.id, u.name AS to_user, .* FROM orders o JOIN users u ON o.recipient_id = u.id WHERE o.user_id = \\$1").
+		WithArgs(1, recentHistoryLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_user"}))
+
+	mock.ExpectQuery("SELECT t.id, u.name AS from_user, .* FROM item_transfers t JOIN users u ON t.sender_id = u.id WHERE t.receiver_id = \\$1").
+		WithArgs(1, recentHistoryLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_user"}))
+
+	mock.ExpectQuery("SELECT t.id, u.name AS to_user, .* FROM item_transfers t JOIN users u ON t.receiver_id = u.id WHERE t.sender_id = \\$1").
+		WithArgs(1, recentHistoryLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_user", "item", "variant", "quantity"}).AddRow(8, "carol", "t-shirt", "t-shirt-m", 1))
+
+	mock.ExpectCommit()
+
 	server := setupTestServer(t, mockDB)
 
 	req, err := http.NewRequest(http.MethodGet, "/api/info", nil)
@@ -69,8 +91,12 @@ func TestGetUserInfo(t *testing.T) {
 
 	assert.Equal(t, 1000, response.Coins)
 	assert.Empty(t, response.Inventory)
-	assert.Empty(t, response.CoinHistory.Received)
+	assert.Equal(t, []CoinTransaction{{ID: 12, FromUser: "system", Amount: 40, Message: "Return of cup", Kind: "refund"}}, response.CoinHistory.Received)
 	assert.Empty(t, response.CoinHistory.Sent)
+	assert.Equal(t, []Gift{{OrderID: 7, FromUser: "bob", Item: "cup", Quantity: 1, Note: "Happy birthday!"}}, response.GiftHistory.Received)
+	assert.Empty(t, response.GiftHistory.Sent)
+	assert.Empty(t, response.ItemHistory.Received)
+	assert.Equal(t, []ItemTransfer{{ID: 8, ToUser: "carol", Item: "t-shirt", Variant: "t-shirt-m", Quantity: 1}}, response.ItemHistory.Sent)
 }
 
 func TestGetUserInfo_Unauthorized(t *testing.T) {
@@ -142,10 +168,14 @@ func TestGetUserInfo_DatabaseError(t *testing.T) {
 	require.NoError(t, err)
 	defer mockDB.Close()
 
+	mock.ExpectBegin()
+
 	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1").
 		WithArgs(1).
 		WillReturnError(sql.ErrNoRows)
 
+	mock.ExpectRollback()
+
 	server := setupTestServer(t, mockDB)
 
 	req, err := http.NewRequest(http.MethodGet, "/api/info", nil)
@@ -162,4 +192,95 @@ func TestGetUserInfo_DatabaseError(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, "Failed to get balance", res["errors"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetUserInfo_ConcurrentUsers(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	mock.MatchExpectationsInOrder(false)
+
+	users := map[int]struct {
+		coins int
+		item  string
+		peer  string
+	}{
+		1: {coins: 100, item: "cup", peer: "bob"},
+		2: {coins: 200, item: "hoody", peer: "alice"},
+	}
+
+	const requestsPerUser = 20
+	for userID, user := range users {
+		for i := 0; i < requestsPerUser; i++ {
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1").
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(user.coins))
+			mock.ExpectQuery("SELECT item, variant, quantity FROM user_inventory WHERE user_id = \\$1").
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"item", "quantity"}).AddRow(user.item, 1))
+			mock.ExpectQuery("SELECT t.id, .* AS sender_id, t.amount, t.message, t.tag, t.kind, t.created_at FROM transactions t").
+				WithArgs(userID, recentHistoryLimit).
+				WillReturnRows(sqlmock.NewRows([]string{"sender_id", "amount"}).AddRow(user.peer, userID))
+			mock.ExpectQuery("SELECT t.id, .* AS receiver_id, t.amount, t.message, t.tag, t.kind, t.created_at FROM transactions t").
+				WithArgs(userID, recentHistoryLimit).
+				WillReturnRows(sqlmock.NewRows([]string{"receiver_id", "amount"}))
+			mock.ExpectQuery("SELECT o.id, u.name AS from_user").
+				WithArgs(userID, recentHistoryLimit).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "from_user"}))
+			mock.ExpectQuery("SELECT o.id, u.name AS to_user").
+				WithArgs(userID, recentHistoryLimit).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "to_user"}))
+			mock.ExpectQuery("SELECT t.id, u.name AS from_user").
+				WithArgs(userID, recentHistoryLimit).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "from_user"}))
+			mock.ExpectQuery("SELECT t.id, u.name AS to_user").
+				WithArgs(userID, recentHistoryLimit).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "to_user"}))
+			mock.ExpectCommit()
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+
+	userHandler := NewUserHandler(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")})
+	r.GET("/api/info/:id", func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.Param("id"))
+		c.Set("userID", userID)
+		userHandler.GetUserInfo(c)
+	})
+
+	var wg sync.WaitGroup
+	for userID, user := range users {
+		for i := 0; i < requestsPerUser; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				req := httptest.NewRequest(http.MethodGet, "/api/info/"+strconv.Itoa(userID), nil)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				if !assert.Equal(t, http.StatusOK, w.Code) {
+					return
+				}
+
+				var response InfoResponse
+				if !assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response)) {
+					return
+				}
+
+				assert.Equal(t, user.coins, response.Coins)
+				assert.Equal(t, []InventoryItem{{Type: user.item, Quantity: 1}}, response.Inventory)
+				assert.Equal(t, []CoinTransaction{{FromUser: user.peer, Amount: userID}}, response.CoinHistory.Received)
+				assert.Empty(t, response.CoinHistory.Sent)
+			}()
+		}
+	}
+	wg.Wait()
+
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
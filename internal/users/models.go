@@ -1,13 +1,18 @@
 package users
 
+import "time"
+
 type InfoResponse struct {
 	Coins       int             `json:"coins" db:"coins"`
 	Inventory   []InventoryItem `json:"inventory"`
 	CoinHistory CoinHistory     `json:"coinHistory"`
+	GiftHistory GiftHistory     `json:"giftHistory"`
+	ItemHistory ItemHistory     `json:"itemHistory"`
 }
 
 type InventoryItem struct {
 	Type     string `json:"type" db:"item"`
+	Variant  string `json:"variant,omitempty" db:"variant"`
 	Quantity int    `json:"quantity" db:"quantity"`
 }
 
@@ -16,10 +21,66 @@ type CoinHistory struct {
 	Sent     []CoinTransaction `json:"sent"`
 }
 
+type GiftHistory struct {
+	Received []Gift `json:"received"`
+	Sent     []Gift `json:"sent"`
+}
+
+// Gift is a merch order bought by one user for another.
+type Gift struct {
+	OrderID   int       `json:"orderId" db:"id"`
+	FromUser  string    `json:"fromUser,omitempty" db:"from_user"`
+	ToUser    string    `json:"toUser,omitempty" db:"to_user"`
+	Item      string    `json:"item" db:"item"`
+	Variant   string    `json:"variant,omitempty" db:"variant"`
+	Quantity  int       `json:"quantity" db:"quantity"`
+	Note      string    `json:"note,omitempty" db:"gift_note"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+type ItemHistory struct {
+	Received []ItemTransfer `json:"received"`
+	Sent     []ItemTransfer `json:"sent"`
+}
+
+// ItemTransfer is owned merch handed over from one user to another.
+type ItemTransfer struct {
+	ID        int       `json:"id" db:"id"`
+	FromUser  string    `json:"fromUser,omitempty" db:"from_user"`
+	ToUser    string    `json:"toUser,omitempty" db:"to_user"`
+	Item      string    `json:"item" db:"item"`
+	Variant   string    `json:"variant,omitempty" db:"variant"`
+	Quantity  int       `json:"quantity" db:"quantity"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// CoinTransaction is a transfer between users or, with a kind other than
+// "transfer", coins paid out by the store, which have no sender.
 type CoinTransaction struct {
-	FromUser string `json:"fromUser,omitempty" db:"sender_id"`
-	ToUser   string `json:"toUser,omitempty" db:"receiver_id"`
-	Amount   int    `json:"amount" db:"amount"`
+	ID        int       `json:"id" db:"id"`
+	FromUser  string    `json:"fromUser,omitempty" db:"sender_id"`
+	ToUser    string    `json:"toUser,omitempty" db:"receiver_id"`
+	Amount    int       `json:"amount" db:"amount"`
+	Message   string    `json:"message,omitempty" db:"message"`
+	Tag       string    `json:"tag,omitempty" db:"tag"`
+	Kind      string    `json:"kind" db:"kind"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+type TransactionEntry struct {
+	ID           int       `json:"id" db:"id"`
+	Direction    string    `json:"direction" db:"direction"`
+	Counterparty string    `json:"counterparty" db:"counterparty"`
+	Amount       int       `json:"amount" db:"amount"`
+	Message      string    `json:"message,omitempty" db:"message"`
+	Tag          string    `json:"tag,omitempty" db:"tag"`
+	Kind         string    `json:"kind" db:"kind"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+}
+
+type TransactionPage struct {
+	Transactions []TransactionEntry `json:"transactions"`
+	NextCursor   string             `json:"nextCursor,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -0,0 +1,128 @@
+package users
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/auth"
+	"github.com/jamsi-max/merch-store/internal/memo"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	directionSent     = "sent"
+	directionReceived = "received"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (u *UserHandler) ListTransactions(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"errors": "Unauthorized"})
+		return
+	}
+
+	where := []string{}
+	args := []interface{}{userID}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	switch c.Query("direction") {
+	case "":
+		where = append(where, "(t.sender_id = $1 OR t.receiver_id = $1)")
+	case directionSent:
+		where = append(where, "t.sender_id = $1")
+	case directionReceived:
+		where = append(where, "t.receiver_id = $1")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid direction"})
+		return
+	}
+
+	// System rows have no user on the other side to match by name.
+	switch counterparty := c.Query("counterparty"); {
+	case strings.EqualFold(counterparty, auth.SystemName):
+		where = append(where, "t.kind <> 'transfer'")
+	case counterparty != "":
+		where = append(where, "u.name = "+arg(counterparty))
+	}
+
+	if tag := c.Query("tag"); tag != "" {
+		if !memo.ValidTag(tag) {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid tag"})
+			return
+		}
+		where = append(where, "t.tag = "+arg(tag))
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		where = append(where, "t.message ILIKE "+arg("%"+likeEscaper.Replace(q)+"%"))
+	}
+
+	for _, bound := range []struct{ param, op string }{{"from", ">="}, {"to", "<"}} {
+		raw, ok := c.GetQuery(bound.param)
+		if !ok {
+			continue
+		}
+
+		ts, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid " + bound.param})
+			return
+		}
+		where = append(where, "t.created_at "+bound.op+" "+arg(ts))
+	}
+
+	if raw, ok := c.GetQuery("cursor"); ok {
+		cursor, err := strconv.Atoi(raw)
+		if err != nil || cursor <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid cursor"})
+			return
+		}
+		where = append(where, "t.id < "+arg(cursor))
+	}
+
+	limit := defaultPageSize
+	if raw, ok := c.GetQuery("limit"); ok {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": "Invalid limit"})
+			return
+		}
+	}
+
+	// One extra row tells whether another page exists without a COUNT query.
+	query := `
+		SELECT t.id,
+			CASE WHEN t.sender_id = $1 THEN 'sent' ELSE 'received' END AS direction,
+			` + counterpartyName + ` AS counterparty, t.amount, t.message, t.tag, t.kind, t.created_at
+		FROM transactions t
+		LEFT JOIN users u ON u.id = CASE WHEN t.sender_id = $1 THEN t.receiver_id ELSE t.sender_id END
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY t.id DESC
+		LIMIT ` + arg(limit+1)
+
+	page := TransactionPage{Transactions: []TransactionEntry{}}
+	if err := u.db.DB.Select(&page.Transactions, query, args...); err != nil {
+		log.Printf("[ERR] failed to list transactions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": "Failed to list transactions"})
+		return
+	}
+
+	if len(page.Transactions) > limit {
+		page.Transactions = page.Transactions[:limit]
+		page.NextCursor = strconv.Itoa(page.Transactions[limit-1].ID)
+	}
+
+	c.JSON(http.StatusOK, page)
+}
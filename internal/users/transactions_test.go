@@ -0,0 +1,117 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jamsi-max/merch-store/internal/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var transactionColumns = []string{"id", "direction", "counterparty", "amount", "message", "tag", "created_at"}
+
+func setupTransactionsServer(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	userHandler := NewUserHandler(&db.Database{DB: sqlx.NewDb(mockDB, "postgres")})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/transactions", func(c *gin.Context) {
+		c.Set("userID", 1)
+		userHandler.ListTransactions(c)
+	})
+
+	return r, mock
+}
+
+func getTransactions(r *gin.Engine, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/transactions"+query, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestListTransactions_Pagination(t *testing.T) {
+	r, mock := setupTransactionsServer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	mock.ExpectQuery(`WHERE \(t.sender_id = \$1 OR t.receiver_id = \$1\) ORDER BY t.id DESC LIMIT \$2`).
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows(transactionColumns).
+			AddRow(9, "sent", "bob", 10, "", "", now).
+			AddRow(7, "received", "alice", 20, "Thanks for the demo", "thanks", now).
+			AddRow(4, "sent", "bob", 30, "", "", now))
+
+	w := getTransactions(r, "?limit=2")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var page TransactionPage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Transactions, 2)
+	assert.Equal(t, "7", page.NextCursor)
+	assert.Equal(t, TransactionEntry{ID: 7, Direction: "received", Counterparty: "alice", Amount: 20, Message: "Thanks for the demo", Tag: "thanks", CreatedAt: now}, page.Transactions[1])
+
+	mock.ExpectQuery(`WHERE \(t.sender_id = \$1 OR t.receiver_id = \$1\) AND t.id < \$2 ORDER BY t.id DESC LIMIT \$3`).
+		WithArgs(1, 7, 3).
+		WillReturnRows(sqlmock.NewRows(transactionColumns).AddRow(4, "sent", "bob", 30, "", "", now))
+
+	w = getTransactions(r, "?limit=2&cursor=7")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	page = TransactionPage{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Transactions, 1)
+	assert.Empty(t, page.NextCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListTransactions_Filters(t *testing.T) {
+	r, mock := setupTransactionsServer(t)
+	from := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`WHERE t.receiver_id = \$1 AND u.name = \$2 AND t.created_at >= \$3 AND t.created_at < \$4 ORDER BY`).
+		WithArgs(1, "alice", from, to, defaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(transactionColumns))
+
+	w := getTransactions(r, "?direction=received&counterparty=alice&from=2025-02-01T00:00:00Z&to=2025-03-01T00:00:00Z")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"transactions": []}`, w.Body.String())
+
+	mock.ExpectQuery(`WHERE \(t.sender_id = \$1 OR t.receiver_id = \$1\) AND t.tag = \$2 AND t.message ILIKE \$3 ORDER BY`).
+		WithArgs(1, "help", `%100\% on\_call%`, defaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(transactionColumns))
+
+	w = getTransactions(r, "?tag=help&q=100%25+on_call")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mock.ExpectQuery(`WHERE \(t.sender_id = \$1 OR t.receiver_id = \$1\) AND t.kind <> 'transfer' ORDER BY`).
+		WithArgs(1, defaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(transactionColumns).AddRow(9, "received", "system", 50, "Hackathon prize", "", from))
+
+	w = getTransactions(r, "?counterparty=system")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"counterparty":"system"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListTransactions_InvalidParams(t *testing.T) {
+	r, mock := setupTransactionsServer(t)
+
+	for _, query := range []string{"?direction=both", "?from=yesterday", "?cursor=abc", "?limit=0", "?limit=101", "?tag=bribe"} {
+		w := getTransactions(r, query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -19,8 +19,8 @@ export default function () {
     let headers = { 'Authorization': TOKEN };
 
     // Покупка товара
-    let buyRes = http.get('http://localhost:8080/api/buy/socks', { headers });
-    check(buyRes, { 'buy success': (r) => r.status === 200 });
+    let buyRes = http.post('http://localhost:8080/api/purchases', JSON.stringify({ item: "socks" }), { headers });
+    check(buyRes, { 'buy success': (r) => r.status === 201 });
 
     // // Передача монет
     let payload = JSON.stringify({ toUser: "user1", amount: 1 });
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Duplicate names belong to different accounts with their own coins and
+-- history, so they are not merged here; resolve them by hand and rerun.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(name || ' (' || n || ' users)', ', ' ORDER BY name) INTO duplicates
+    FROM (SELECT name, COUNT(*) AS n FROM users GROUP BY name HAVING COUNT(*) > 1) d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'cannot make user names unique, duplicate names: %', duplicates
+            USING ERRCODE = 'unique_violation',
+                  HINT = 'Rename or remove the duplicate users, then rerun the migration.';
+    END IF;
+END;
+$$;
+
+ALTER TABLE users ADD CONSTRAINT users_name_key UNIQUE (name);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_name_key;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE merch
+    ADD COLUMN IF NOT EXISTS "description" TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS "category" TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS "active" BOOLEAN NOT NULL DEFAULT true,
+    ADD COLUMN IF NOT EXISTS "updated_at" TIMESTAMP WITH TIME ZONE DEFAULT now();
+
+UPDATE merch SET category = 'clothing' WHERE name IN ('t-shirt', 'hoody', 'pink-hoody', 'socks');
+UPDATE merch SET category = 'accessories' WHERE name IN ('cup', 'umbrella', 'wallet');
+UPDATE merch SET category = 'stationery' WHERE name IN ('book', 'pen');
+UPDATE merch SET category = 'electronics' WHERE name = 'powerbank';
+
+CREATE OR REPLACE FUNCTION notify_merch_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('merch_changed', TG_TABLE_NAME);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER merch_changed
+    AFTER INSERT OR UPDATE OR DELETE ON merch
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_merch_changed();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS merch_changed ON merch;
+DROP FUNCTION IF EXISTS notify_merch_changed();
+ALTER TABLE merch
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS active,
+    DROP COLUMN IF EXISTS category,
+    DROP COLUMN IF EXISTS description;
+-- +goose StatementEnd
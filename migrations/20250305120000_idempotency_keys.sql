@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    "user_id" INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    "key" TEXT NOT NULL,
+    "endpoint" TEXT NOT NULL,
+    "request_hash" TEXT NOT NULL,
+    "status_code" INT,
+    "response_body" BYTEA,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS idempotency_keys;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS transactions_sender_id_idx ON transactions (sender_id, id DESC);
+CREATE INDEX IF NOT EXISTS transactions_receiver_id_idx ON transactions (receiver_id, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS transactions_receiver_id_idx;
+DROP INDEX IF EXISTS transactions_sender_id_idx;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS "message" TEXT NOT NULL DEFAULT '' CHECK (char_length(message) <= 280),
+    ADD COLUMN IF NOT EXISTS "tag" TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions
+    DROP COLUMN IF EXISTS "tag",
+    DROP COLUMN IF EXISTS "message";
+-- +goose StatementEnd
@@ -0,0 +1,69 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    "code" TEXT PRIMARY KEY,
+    "user_id" INT UNIQUE REFERENCES users(id) ON DELETE SET NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_txns (
+    "id" SERIAL PRIMARY KEY,
+    "kind" TEXT NOT NULL CHECK (kind IN ('opening', 'grant', 'transfer', 'purchase')),
+    "reference" TEXT NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    "id" SERIAL PRIMARY KEY,
+    "txn_id" INT NOT NULL REFERENCES ledger_txns(id),
+    "account" TEXT NOT NULL REFERENCES ledger_accounts(code),
+    "amount" INT NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_txn_id_idx ON ledger_entries (txn_id);
+CREATE INDEX IF NOT EXISTS ledger_entries_account_idx ON ledger_entries (account);
+
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM ledger_entries WHERE txn_id = NEW.txn_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.txn_id
+            USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+    AFTER INSERT OR UPDATE ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
+
+INSERT INTO ledger_accounts (code) VALUES ('system:issuance'), ('system:store')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO ledger_accounts (code, user_id)
+SELECT 'user:' || id, id FROM users
+ON CONFLICT (code) DO NOTHING;
+
+-- Existing balances have no history, so each one becomes an opening
+-- transaction funded from the issuance pool.
+WITH opening AS (
+    INSERT INTO ledger_txns (kind, reference)
+    SELECT 'opening', 'user:' || id FROM users WHERE COALESCE(coins, 0) > 0
+    RETURNING id, reference
+)
+INSERT INTO ledger_entries (txn_id, account, amount)
+SELECT o.id, o.reference, u.coins
+FROM opening o JOIN users u ON 'user:' || u.id = o.reference
+UNION ALL
+SELECT o.id, 'system:issuance', -u.coins
+FROM opening o JOIN users u ON 'user:' || u.id = o.reference;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ledger_entries;
+DROP FUNCTION IF EXISTS ledger_check_balanced();
+DROP TABLE IF EXISTS ledger_txns;
+DROP TABLE IF EXISTS ledger_accounts;
+-- +goose StatementEnd
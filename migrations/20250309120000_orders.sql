@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS orders (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    "item" TEXT NOT NULL,
+    "unit_price" INT NOT NULL CHECK (unit_price >= 0),
+    "quantity" INT NOT NULL DEFAULT 1 CHECK (quantity > 0),
+    "status" TEXT NOT NULL DEFAULT 'placed' CHECK (status IN ('placed', 'cancelled')),
+    "ledger_txn_id" INT REFERENCES ledger_txns(id),
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    "updated_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id, id DESC);
+
+-- Purchases made before orders existed only left a quantity behind, so they
+-- become one order per item priced at the current catalog price.
+INSERT INTO orders (user_id, item, unit_price, quantity)
+SELECT um.user_id, um.item, COALESCE(m.price, 0), um.quantity
+FROM user_merch um
+LEFT JOIN merch m ON m.name = um.item
+WHERE um.user_id IS NOT NULL AND um.quantity > 0
+ORDER BY um.id;
+
+-- Orders are the only record of purchases from here on; user_merch is
+-- retired so nothing keeps reading quantities that no longer change.
+DROP TABLE IF EXISTS user_merch;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_merch (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" INT NOT NULL REFERENCES users(id) ON DELETE SET NULL,
+    "item" TEXT NOT NULL,
+    "quantity" INT NOT NULL,
+    UNIQUE (user_id, item)
+);
+
+INSERT INTO user_merch (user_id, item, quantity)
+SELECT user_id, item, SUM(quantity)
+FROM orders
+WHERE status <> 'cancelled'
+GROUP BY user_id, item;
+
+DROP TABLE IF EXISTS orders;
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('placed', 'approved', 'ready_for_pickup', 'delivered', 'cancelled'));
+
+ALTER TABLE ledger_txns DROP CONSTRAINT IF EXISTS ledger_txns_kind_check;
+ALTER TABLE ledger_txns ADD CONSTRAINT ledger_txns_kind_check
+    CHECK (kind IN ('opening', 'grant', 'transfer', 'purchase', 'refund'));
+
+CREATE TABLE IF NOT EXISTS order_events (
+    "id" SERIAL PRIMARY KEY,
+    "order_id" INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    "from_status" TEXT NOT NULL,
+    "to_status" TEXT NOT NULL,
+    "actor_id" INT REFERENCES users(id) ON DELETE SET NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id);
+CREATE INDEX IF NOT EXISTS orders_status_idx ON orders (status, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS orders_status_idx;
+DROP TABLE IF EXISTS order_events;
+
+ALTER TABLE ledger_txns DROP CONSTRAINT IF EXISTS ledger_txns_kind_check;
+ALTER TABLE ledger_txns ADD CONSTRAINT ledger_txns_kind_check
+    CHECK (kind IN ('opening', 'grant', 'transfer', 'purchase'));
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('placed', 'cancelled'));
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- NULL stock means the item is sold without a limit.
+ALTER TABLE merch ADD COLUMN IF NOT EXISTS "stock" INT CHECK (stock >= 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE merch DROP COLUMN IF EXISTS stock;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Items with active variants can only be bought as one of them; NULL stock
+-- means the variant is sold without a limit. No variants are seeded, so
+-- existing items stay purchasable without a SKU until an admin adds some.
+CREATE TABLE IF NOT EXISTS merch_variants (
+    "id" SERIAL PRIMARY KEY,
+    "item" TEXT NOT NULL REFERENCES merch(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    "sku" TEXT NOT NULL UNIQUE,
+    "size" TEXT NOT NULL DEFAULT '',
+    "color" TEXT NOT NULL DEFAULT '',
+    "price_delta" INT NOT NULL DEFAULT 0,
+    "stock" INT CHECK (stock >= 0),
+    "active" BOOLEAN NOT NULL DEFAULT true,
+    UNIQUE (item, size, color)
+);
+
+CREATE TRIGGER merch_variants_changed
+    AFTER INSERT OR UPDATE OR DELETE ON merch_variants
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_merch_changed();
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS "variant" TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE orders DROP COLUMN IF EXISTS variant;
+DROP TABLE IF EXISTS merch_variants;
+-- +goose StatementEnd
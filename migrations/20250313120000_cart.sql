@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- unit_price is the price quoted when the line was last added; checkout
+-- refuses to charge a different one. variant is '' for items without
+-- variants so it can be part of the key.
+CREATE TABLE IF NOT EXISTS cart_items (
+    "user_id" INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    "item" TEXT NOT NULL REFERENCES merch(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    "variant" TEXT NOT NULL DEFAULT '',
+    "quantity" INT NOT NULL CHECK (quantity BETWEEN 1 AND 99),
+    "unit_price" INT NOT NULL CHECK (unit_price > 0),
+    "updated_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, item, variant)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS cart_items;
+-- +goose StatementEnd
@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A promo code without an item applies store-wide. Percentage discounts
+-- round down to whole coins, and no discount takes a price below 1 coin.
+CREATE TABLE IF NOT EXISTS promo_codes (
+    "code" TEXT PRIMARY KEY,
+    "kind" TEXT NOT NULL CHECK (kind IN ('percent', 'fixed')),
+    "value" INT NOT NULL CHECK (value > 0 AND (kind <> 'percent' OR value <= 100)),
+    "item" TEXT REFERENCES merch(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    "max_uses" INT CHECK (max_uses > 0),
+    "max_uses_per_user" INT CHECK (max_uses_per_user > 0),
+    "uses" INT NOT NULL DEFAULT 0,
+    "starts_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    "ends_at" TIMESTAMP WITH TIME ZONE,
+    "active" BOOLEAN NOT NULL DEFAULT true,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    "id" SERIAL PRIMARY KEY,
+    "code" TEXT NOT NULL REFERENCES promo_codes(code),
+    "user_id" INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    "discount" INT NOT NULL CHECK (discount > 0),
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS promo_redemptions_code_user_idx ON promo_redemptions (code, user_id);
+
+-- unit_price stays what the buyer actually paid per unit, so refunds are
+-- unchanged; list_price is the catalog price before the discount.
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS "list_price" INT,
+    ADD COLUMN IF NOT EXISTS "promo_code" TEXT REFERENCES promo_codes(code);
+UPDATE orders SET list_price = unit_price WHERE list_price IS NULL;
+ALTER TABLE orders ALTER COLUMN list_price SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS promo_code,
+    DROP COLUMN IF EXISTS list_price;
+DROP TABLE IF EXISTS promo_redemptions;
+DROP TABLE IF EXISTS promo_codes;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A rule replaces the item price while it is in effect; without ends_at it
+-- is a lasting price change. Overlapping rules are allowed, the one that
+-- started last wins.
+CREATE TABLE IF NOT EXISTS price_rules (
+    "id" SERIAL PRIMARY KEY,
+    "item" TEXT NOT NULL REFERENCES merch(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    "label" TEXT NOT NULL DEFAULT '',
+    "sale_price" INT NOT NULL CHECK (sale_price > 0),
+    "starts_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "ends_at" TIMESTAMP WITH TIME ZONE CHECK (ends_at > starts_at),
+    "created_by" INT REFERENCES users(id) ON DELETE SET NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS price_rules_ends_at_idx ON price_rules (ends_at);
+
+CREATE TRIGGER price_rules_changed
+    AFTER INSERT OR UPDATE OR DELETE ON price_rules
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_merch_changed();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS price_rules;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A gift is an order paid by user_id for recipient_id; the item counts
+-- towards the recipient's inventory and a refund still goes to the buyer.
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS "recipient_id" INT REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS "gift_note" TEXT NOT NULL DEFAULT '' CHECK (char_length(gift_note) <= 280),
+    ADD CONSTRAINT orders_gift_recipient_check CHECK (recipient_id <> user_id);
+
+CREATE INDEX IF NOT EXISTS orders_recipient_id_idx ON orders (recipient_id, id DESC) WHERE recipient_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE orders
+    DROP CONSTRAINT IF EXISTS orders_gift_recipient_check,
+    DROP COLUMN IF EXISTS gift_note,
+    DROP COLUMN IF EXISTS recipient_id;
+-- +goose StatementEnd
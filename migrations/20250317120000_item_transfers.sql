@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS item_transfers (
+    "id" SERIAL PRIMARY KEY,
+    "sender_id" INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    "receiver_id" INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    "item" TEXT NOT NULL,
+    "variant" TEXT NOT NULL DEFAULT '',
+    "quantity" INT NOT NULL CHECK (quantity > 0),
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    CONSTRAINT item_transfers_parties_check CHECK (sender_id <> receiver_id)
+);
+
+CREATE INDEX IF NOT EXISTS item_transfers_sender_id_idx ON item_transfers (sender_id, id DESC);
+CREATE INDEX IF NOT EXISTS item_transfers_receiver_id_idx ON item_transfers (receiver_id, id DESC);
+
+-- What a user holds: what they bought or were gifted, plus what was handed
+-- to them, minus what they handed over.
+CREATE OR REPLACE VIEW user_inventory AS
+SELECT user_id, item, variant, SUM(quantity)::INT AS quantity
+FROM (
+    SELECT COALESCE(recipient_id, user_id) AS user_id, item, COALESCE(variant, '') AS variant, quantity
+    FROM orders
+    WHERE status <> 'cancelled'
+    UNION ALL
+    SELECT receiver_id, item, variant, quantity FROM item_transfers
+    UNION ALL
+    SELECT sender_id, item, variant, -quantity FROM item_transfers
+) holdings
+GROUP BY user_id, item, variant
+HAVING SUM(quantity) > 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP VIEW IF EXISTS user_inventory;
+DROP TABLE IF EXISTS item_transfers;
+-- +goose StatementEnd
@@ -0,0 +1,79 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS "returned_quantity" INT NOT NULL DEFAULT 0,
+    ADD CONSTRAINT orders_returned_quantity_check CHECK (returned_quantity BETWEEN 0 AND quantity);
+
+CREATE TABLE IF NOT EXISTS order_returns (
+    "id" SERIAL PRIMARY KEY,
+    "order_id" INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    "quantity" INT NOT NULL CHECK (quantity > 0),
+    "reason" TEXT NOT NULL DEFAULT '' CHECK (char_length(reason) <= 280),
+    "status" TEXT NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'approved', 'rejected')),
+    "refund_amount" INT CHECK (refund_amount >= 0),
+    "decided_by" INT REFERENCES users(id) ON DELETE SET NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    "decided_at" TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS order_returns_order_id_idx ON order_returns (order_id);
+CREATE INDEX IF NOT EXISTS order_returns_status_idx ON order_returns (status, id DESC);
+
+-- Coin history now also carries money the system pays out, such as refunds
+-- for returns; those rows have no sender.
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS "kind" TEXT NOT NULL DEFAULT 'transfer' CHECK (kind IN ('transfer', 'refund'));
+
+CREATE OR REPLACE VIEW user_inventory AS
+SELECT user_id, item, variant, SUM(quantity)::INT AS quantity
+FROM (
+    SELECT COALESCE(recipient_id, user_id) AS user_id, item, COALESCE(variant, '') AS variant, quantity - returned_quantity AS quantity
+    FROM orders
+    WHERE status <> 'cancelled'
+    UNION ALL
+    SELECT receiver_id, item, variant, quantity FROM item_transfers
+    UNION ALL
+    SELECT sender_id, item, variant, -quantity FROM item_transfers
+) holdings
+GROUP BY user_id, item, variant
+HAVING SUM(quantity) > 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE VIEW user_inventory AS
+SELECT user_id, item, variant, SUM(quantity)::INT AS quantity
+FROM (
+    SELECT COALESCE(recipient_id, user_id) AS user_id, item, COALESCE(variant, '') AS variant, quantity
+    FROM orders
+    WHERE status <> 'cancelled'
+    UNION ALL
+    SELECT receiver_id, item, variant, quantity FROM item_transfers
+    UNION ALL
+    SELECT sender_id, item, variant, -quantity FROM item_transfers
+) holdings
+GROUP BY user_id, item, variant
+HAVING SUM(quantity) > 0;
+
+-- Refunds are ledger postings too; deleting only their history rows would
+-- leave /api/info out of step with balances, so refuse while any exist.
+DO $$
+DECLARE
+    n INT;
+BEGIN
+    SELECT COUNT(*) INTO n FROM transactions WHERE kind <> 'transfer';
+    IF n > 0 THEN
+        RAISE EXCEPTION 'cannot roll back: % coin history rows are refunds with ledger postings', n
+            USING HINT = 'Roll back only before any order was refunded.';
+    END IF;
+END;
+$$;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS "kind";
+
+DROP TABLE IF EXISTS order_returns;
+
+ALTER TABLE orders
+    DROP CONSTRAINT IF EXISTS orders_returned_quantity_check,
+    DROP COLUMN IF EXISTS returned_quantity;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE ledger_txns DROP CONSTRAINT IF EXISTS ledger_txns_kind_check;
+ALTER TABLE ledger_txns ADD CONSTRAINT ledger_txns_kind_check
+    CHECK (kind IN ('opening', 'grant', 'transfer', 'purchase', 'refund', 'deduction'));
+
+-- Grants have no sender and deductions no receiver; actor_id is the admin
+-- who made them.
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_kind_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_kind_check
+    CHECK (kind IN ('transfer', 'refund', 'grant', 'deduction'));
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS "actor_id" INT REFERENCES users(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- The coins granted or deducted stay in the ledger, so their history rows
+-- cannot simply be dropped.
+DO $$
+DECLARE
+    n INT;
+BEGIN
+    SELECT COUNT(*) INTO n FROM transactions WHERE kind IN ('grant', 'deduction');
+    IF n > 0 THEN
+        RAISE EXCEPTION 'cannot roll back: % coin history rows are grants or deductions with ledger postings', n
+            USING HINT = 'Roll back only before any balance adjustments were made.';
+    END IF;
+END;
+$$;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS "actor_id";
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_kind_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_kind_check
+    CHECK (kind IN ('transfer', 'refund'));
+
+ALTER TABLE ledger_txns DROP CONSTRAINT IF EXISTS ledger_txns_kind_check;
+ALTER TABLE ledger_txns ADD CONSTRAINT ledger_txns_kind_check
+    CHECK (kind IN ('opening', 'grant', 'transfer', 'purchase', 'refund'));
+-- +goose StatementEnd
@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Only active users receive the monthly allowance; deactivating someone
+-- does not touch their balance or their access.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "active" BOOLEAN NOT NULL DEFAULT true;
+
+-- One row per user and month (YYYY-MM, UTC) makes the accrual idempotent.
+CREATE TABLE IF NOT EXISTS allowance_credits (
+    "user_id" INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    "period" TEXT NOT NULL CHECK (period ~ '^[0-9]{4}-[0-9]{2}$'),
+    "amount" INT NOT NULL CHECK (amount > 0),
+    "ledger_txn_id" INT NOT NULL REFERENCES ledger_txns(id),
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, period)
+);
+
+ALTER TABLE ledger_txns DROP CONSTRAINT IF EXISTS ledger_txns_kind_check;
+ALTER TABLE ledger_txns ADD CONSTRAINT ledger_txns_kind_check
+    CHECK (kind IN ('opening', 'grant', 'transfer', 'purchase', 'refund', 'deduction', 'allowance'));
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_kind_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_kind_check
+    CHECK (kind IN ('transfer', 'refund', 'grant', 'deduction', 'allowance'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Credited allowances stay in the ledger, so the rollback is refused
+-- rather than dropping their history rows.
+DO $$
+DECLARE
+    n INT;
+BEGIN
+    SELECT COUNT(*) INTO n FROM transactions WHERE kind = 'allowance';
+    IF n > 0 THEN
+        RAISE EXCEPTION 'cannot roll back: % coin history rows are allowances with ledger postings', n
+            USING HINT = 'Roll back only before any allowance was credited.';
+    END IF;
+END;
+$$;
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_kind_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_kind_check
+    CHECK (kind IN ('transfer', 'refund', 'grant', 'deduction'));
+
+ALTER TABLE ledger_txns DROP CONSTRAINT IF EXISTS ledger_txns_kind_check;
+ALTER TABLE ledger_txns ADD CONSTRAINT ledger_txns_kind_check
+    CHECK (kind IN ('opening', 'grant', 'transfer', 'purchase', 'refund', 'deduction'));
+
+DROP TABLE IF EXISTS allowance_credits;
+ALTER TABLE users DROP COLUMN IF EXISTS "active";
+-- +goose StatementEnd
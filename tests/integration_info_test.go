@@ -15,6 +15,7 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 
+	"github.com/jamsi-max/merch-store/config"
 	"github.com/jamsi-max/merch-store/internal/db"
 	"github.com/jamsi-max/merch-store/internal/router"
 )
@@ -84,31 +85,95 @@ func seedTestData(db *db.Database) {
 	db.DB.MustExec(`CREATE TABLE IF NOT EXISTS merch (
 		id SERIAL PRIMARY KEY,
 		name TEXT NOT NULL,
-		price INT NOT NULL
+		price INT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		category TEXT NOT NULL DEFAULT '',
+		active BOOLEAN NOT NULL DEFAULT true,
+		stock INT
 	)`)
 
-	db.DB.MustExec(`CREATE TABLE IF NOT EXISTS user_merch (
-		user_id INT REFERENCES users(id),
+	db.DB.MustExec(`CREATE TABLE IF NOT EXISTS merch_variants (
+		id SERIAL PRIMARY KEY,
+		item TEXT NOT NULL,
+		sku TEXT NOT NULL UNIQUE,
+		size TEXT NOT NULL DEFAULT '',
+		color TEXT NOT NULL DEFAULT '',
+		price_delta INT NOT NULL DEFAULT 0,
+		stock INT,
+		active BOOLEAN NOT NULL DEFAULT true
+	)`)
+
+	db.DB.MustExec(`CREATE TABLE IF NOT EXISTS price_rules (
+		id SERIAL PRIMARY KEY,
+		item TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		sale_price INT NOT NULL,
+		starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		ends_at TIMESTAMP WITH TIME ZONE
+	)`)
+
+	db.DB.MustExec(`CREATE TABLE IF NOT EXISTS orders (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id),
 		item TEXT NOT NULL,
-		quantity INT NOT NULL
+		variant TEXT,
+		unit_price INT NOT NULL,
+		list_price INT NOT NULL DEFAULT 0,
+		promo_code TEXT,
+		quantity INT NOT NULL DEFAULT 1,
+		status TEXT NOT NULL DEFAULT 'placed',
+		ledger_txn_id INT,
+		recipient_id INT,
+		gift_note TEXT NOT NULL DEFAULT '',
+		returned_quantity INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
 	)`)
 
+	db.DB.MustExec(`CREATE TABLE IF NOT EXISTS item_transfers (
+		id SERIAL PRIMARY KEY,
+		sender_id INT NOT NULL REFERENCES users(id),
+		receiver_id INT NOT NULL REFERENCES users(id),
+		item TEXT NOT NULL,
+		variant TEXT NOT NULL DEFAULT '',
+		quantity INT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+	)`)
+
+	db.DB.MustExec(`CREATE OR REPLACE VIEW user_inventory AS
+	SELECT user_id, item, variant, SUM(quantity)::INT AS quantity
+	FROM (
+		SELECT COALESCE(recipient_id, user_id) AS user_id, item, COALESCE(variant, '') AS variant, quantity - returned_quantity AS quantity
+		FROM orders
+		WHERE status <> 'cancelled'
+		UNION ALL
+		SELECT receiver_id, item, variant, quantity FROM item_transfers
+		UNION ALL
+		SELECT sender_id, item, variant, -quantity FROM item_transfers
+	) holdings
+	GROUP BY user_id, item, variant
+	HAVING SUM(quantity) > 0`)
+
 	db.DB.MustExec(`CREATE TABLE IF NOT EXISTS transactions (
 		id SERIAL PRIMARY KEY,
 		sender_id INT REFERENCES users(id),
 		receiver_id INT REFERENCES users(id),
-		amount INT NOT NULL
+		amount INT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		tag TEXT NOT NULL DEFAULT '',
+		kind TEXT NOT NULL DEFAULT 'transfer',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
 	)`)
 
 	db.DB.MustExec("INSERT INTO users (id, name, pass, coins) VALUES (1, 'testuser', 'password', 500)")
 	db.DB.MustExec("INSERT INTO users (id, name, pass, coins) VALUES (2, 'sender', 'password', 300)")
-	db.DB.MustExec("INSERT INTO user_merch (user_id, item, quantity) VALUES (1, 'sword', 2), (1, 'shield', 1)")
+	db.DB.MustExec("INSERT INTO orders (user_id, item, unit_price, quantity) VALUES (1, 'sword', 100, 2), (1, 'shield', 50, 1)")
 	db.DB.MustExec("INSERT INTO transactions (sender_id, receiver_id, amount) VALUES (2, 1, 100)")
 }
 
 func TestGetUserInfo(t *testing.T) {
 	db := setupTestDB(t)
-	r := router.SetupRouter(db, "testsecret")
+	r := router.SetupRouter(context.Background(), db, &config.Config{JWTSecret: "testsecret"})
 
 	req, _ := http.NewRequest("GET", "/api/info", nil)
 	req.Header.Set("Authorization", jwtToken)
@@ -131,11 +196,6 @@ func applyMigrations(db *db.Database) error {
 		pass TEXT NOT NULL,
 		coins INT NOT NULL
 	);
-	CREATE TABLE IF NOT EXISTS user_merch (
-		user_id INT REFERENCES users(id),
-		item TEXT NOT NULL,
-		quantity INT NOT NULL
-	);
 	CREATE TABLE IF NOT EXISTS transactions (
 		id SERIAL PRIMARY KEY,
 		sender_id INT REFERENCES users(id),